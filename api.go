@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"cf-monitoring-demo-app/workload"
+)
+
+const apiRunsPath = "/api/v1/runs"
+
+// apiRunRequest is a run request, the target is either set as is
+// or as the backend and the instance names, e.g. "mysql/orders" or
+// {"backend": "mysql", "instance": "orders"}.
+type apiRunRequest struct {
+	Target      string  `json:"target,omitempty"`
+	Backend     string  `json:"backend,omitempty"`
+	Instance    string  `json:"instance,omitempty"`
+	Duration    string  `json:"duration,omitempty"`
+	Concurrency int     `json:"concurrency,omitempty"`
+	Rate        float64 `json:"rate,omitempty"`
+	Profile     string  `json:"profile,omitempty"`
+
+	// Options are driver specific, e.g. {"confirm": "true"}.
+	Options map[string]string `json:"options,omitempty"`
+}
+
+type apiRun struct {
+	ID          string            `json:"id"`
+	Target      string            `json:"target"`
+	Backend     string            `json:"backend"`
+	Instance    string            `json:"instance,omitempty"`
+	Namespace   string            `json:"namespace"`
+	Status      string            `json:"status"`
+	Error       string            `json:"error,omitempty"`
+	TeardownErr string            `json:"teardown_error,omitempty"`
+	Duration    string            `json:"duration"`
+	Concurrency int               `json:"concurrency"`
+	Rate        float64           `json:"rate,omitempty"`
+	Profile     string            `json:"profile,omitempty"`
+	Options     map[string]string `json:"options,omitempty"`
+	StartedAt   time.Time         `json:"started_at"`
+	FinishedAt  *time.Time        `json:"finished_at,omitempty"`
+	ElapsedSec  float64           `json:"elapsed_sec"`
+	Stats       []apiOpStat       `json:"stats"`
+	ScheduleLag *apiOpStat        `json:"schedule_lag,omitempty"`
+	Gauges      map[string]int64  `json:"gauges,omitempty"`
+}
+
+type apiOpStat struct {
+	Op     string  `json:"op"`
+	Count  uint64  `json:"count"`
+	Errors uint64  `json:"errors"`
+	Rate   float64 `json:"rate"`
+	P50Ms  float64 `json:"p50_ms"`
+	P90Ms  float64 `json:"p90_ms"`
+	P99Ms  float64 `json:"p99_ms"`
+	MaxMs  float64 `json:"max_ms"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// handleAPIRuns serves the runs collection:
+//
+//	GET    /api/v1/runs      lists runs
+//	POST   /api/v1/runs      starts a new run
+//	GET    /api/v1/runs/:id  returns a single run
+//	DELETE /api/v1/runs/:id  stops a run
+func handleAPIRuns(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, apiRunsPath), "/")
+	if id == "" {
+		switch r.Method {
+		case http.MethodGet:
+			apiListRuns(w)
+		case http.MethodPost:
+			apiStartRun(w, r)
+		default:
+			apiMethodNotAllowed(w, "GET, POST")
+		}
+		return
+	}
+
+	j := findJob(id)
+	if j == nil {
+		writeJSON(w, http.StatusNotFound, apiError{fmt.Sprintf("run %s not found", id)})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, j.view())
+	case http.MethodDelete:
+		mu.Lock()
+		running := j.status == statusRunning
+		mu.Unlock()
+
+		if !running {
+			writeJSON(w, http.StatusConflict, apiError{fmt.Sprintf("run %s is not running", id)})
+			return
+		}
+		j.cancel()
+		writeJSON(w, http.StatusAccepted, j.view())
+	default:
+		apiMethodNotAllowed(w, "GET, DELETE")
+	}
+}
+
+func apiListRuns(w http.ResponseWriter) {
+	mu.Lock()
+	list := append([]*job(nil), runs...)
+	mu.Unlock()
+
+	res := make([]apiRun, 0, len(list))
+	for _, j := range list {
+		res = append(res, j.view())
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func apiStartRun(w http.ResponseWriter, r *http.Request) {
+	var req apiRunRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{fmt.Sprintf("malformed request: %v", err)})
+		return
+	}
+
+	id := req.Target
+	if id == "" {
+		id = req.Backend
+		if req.Instance != "" {
+			id += "/" + req.Instance
+		}
+	}
+
+	t := lookupTarget(id)
+	if t == nil {
+		writeJSON(w, http.StatusNotFound, apiError{fmt.Sprintf("target %q not found or not configured", id)})
+		return
+	}
+
+	p, err := req.params(t)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{err.Error()})
+		return
+	}
+
+	j, err := startJob(t, p)
+	switch {
+	case err == errBusy:
+		writeJSON(w, http.StatusConflict, apiError{fmt.Sprintf("%s is busy now", id)})
+		return
+	case err == errShutdown:
+		writeJSON(w, http.StatusServiceUnavailable, apiError{err.Error()})
+		return
+	case err != nil:
+		writeJSON(w, http.StatusBadRequest, apiError{err.Error()})
+		return
+	}
+
+	w.Header().Set("Location", apiRunsPath+"/"+j.id)
+	writeJSON(w, http.StatusCreated, j.view())
+}
+
+func (req *apiRunRequest) params(t *target) (runParams, error) {
+	p := defaultParams(t)
+	if req.Duration != "" {
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil {
+			return p, fmt.Errorf("invalid duration: %v", err)
+		}
+		if d <= 0 {
+			return p, fmt.Errorf("duration must be positive")
+		}
+		p.Duration = d
+	}
+	if req.Concurrency != 0 {
+		p.Concurrency = req.Concurrency
+	}
+	if req.Rate != 0 {
+		p.Rate = req.Rate
+	}
+	if req.Profile != "" {
+		p.Profile = req.Profile
+	}
+	p.Options = p.Options.Merge(req.Options)
+
+	switch {
+	case req.Concurrency < 0:
+		return p, fmt.Errorf("concurrency must be positive")
+	case req.Concurrency > maxWorkers:
+		return p, fmt.Errorf("concurrency must not exceed %d", maxWorkers)
+	case req.Rate < 0:
+		return p, fmt.Errorf("rate must be positive")
+	}
+	return p, nil
+}
+
+func (j *job) view() apiRun {
+	mu.Lock()
+	v := apiRun{
+		ID:          j.id,
+		Target:      j.target.ID(),
+		Backend:     j.target.Name,
+		Instance:    j.target.Instance,
+		Namespace:   j.namespace,
+		Status:      j.status,
+		Duration:    j.params.Duration.String(),
+		Concurrency: j.params.Concurrency,
+		Rate:        j.params.Rate,
+		Profile:     j.params.Profile,
+		Options:     j.params.Options,
+		StartedAt:   j.started,
+	}
+	if j.err != nil {
+		v.Error = j.err.Error()
+	}
+	if j.tderr != nil {
+		v.TeardownErr = j.tderr.Error()
+	}
+	if !j.finished.IsZero() {
+		t := j.finished
+		v.FinishedAt = &t
+	}
+	mu.Unlock()
+
+	v.ElapsedSec = j.stats.Elapsed().Seconds()
+	v.Stats = []apiOpStat{}
+	for _, o := range j.stats.Snapshot() {
+		v.Stats = append(v.Stats, newAPIOpStat(o))
+	}
+	if l := j.stats.Lag(); l.Count != 0 {
+		s := newAPIOpStat(l)
+		v.ScheduleLag = &s
+	}
+	for _, g := range j.stats.Gauges() {
+		if v.Gauges == nil {
+			v.Gauges = map[string]int64{}
+		}
+		v.Gauges[g.Name] = g.Value
+	}
+	return v
+}
+
+func newAPIOpStat(o workload.OpStats) apiOpStat {
+	return apiOpStat{
+		Op:     o.Op,
+		Count:  o.Count,
+		Errors: o.Errors,
+		Rate:   o.Rate,
+		P50Ms:  ms(o.P50),
+		P90Ms:  ms(o.P90),
+		P99Ms:  ms(o.P99),
+		MaxMs:  ms(o.Max),
+	}
+}
+
+func ms(d time.Duration) float64 {
+	return d.Seconds() * 1000
+}
+
+func apiMethodNotAllowed(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	writeJSON(w, http.StatusMethodNotAllowed, apiError{http.StatusText(http.StatusMethodNotAllowed)})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"cf-monitoring-demo-app/workload"
+)
+
+// idleWorkload steps until the run is over doing nothing.
+type idleWorkload struct{}
+
+func (idleWorkload) Name() string                        { return "idle" }
+func (idleWorkload) Configure(cfg workload.Config) error { return nil }
+func (idleWorkload) Setup(ctx context.Context) error     { return nil }
+func (idleWorkload) Teardown(ctx context.Context) error  { return nil }
+
+func (idleWorkload) Step(ctx context.Context, i int) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// serveAPI serves a single runs API request and returns the response.
+func serveAPI(method, path, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handleAPIRuns(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return rec
+}
+
+func TestAPIRuns(t *testing.T) {
+	defer func(ts []*target) { enabled = ts }(enabled)
+	enabled = []*target{{Driver: &workload.Driver{
+		Name: "idle",
+		New:  func() workload.Workload { return idleWorkload{} },
+	}}}
+
+	for _, c := range []struct {
+		method, path, body string
+		status             int
+	}{
+		{"GET", apiRunsPath, "", http.StatusOK},
+		{"PUT", apiRunsPath, "", http.StatusMethodNotAllowed},
+		{"POST", apiRunsPath, "{", http.StatusBadRequest},
+		{"POST", apiRunsPath, `{"target": "missing"}`, http.StatusNotFound},
+		{"POST", apiRunsPath, `{"target": "idle", "duration": "-1s"}`, http.StatusBadRequest},
+		{"POST", apiRunsPath, `{"target": "idle", "concurrency": 1000}`, http.StatusBadRequest},
+		{"POST", apiRunsPath, `{"target": "idle", "profile": "zigzag"}`, http.StatusBadRequest},
+		{"GET", apiRunsPath + "/0", "", http.StatusNotFound},
+	} {
+		if rec := serveAPI(c.method, c.path, c.body); rec.Code != c.status {
+			t.Errorf("%s %s %s: %d, want %d", c.method, c.path, c.body, rec.Code, c.status)
+		}
+	}
+
+	rec := serveAPI("POST", apiRunsPath, `{"backend": "idle", "duration": "1m"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("starting a run: %d %s", rec.Code, rec.Body)
+	}
+	path := rec.Header().Get("Location")
+
+	for _, c := range []struct {
+		method, path, body string
+		status             int
+	}{
+		{"POST", apiRunsPath, `{"target": "idle"}`, http.StatusConflict},
+		{"GET", path, "", http.StatusOK},
+		{"PATCH", path, "", http.StatusMethodNotAllowed},
+		{"DELETE", path, "", http.StatusAccepted},
+	} {
+		if rec := serveAPI(c.method, c.path, c.body); rec.Code != c.status {
+			t.Errorf("%s %s %s: %d, want %d", c.method, c.path, c.body, rec.Code, c.status)
+		}
+	}
+
+	wg.Wait()
+	if rec := serveAPI("DELETE", path, ""); rec.Code != http.StatusConflict {
+		t.Errorf("stopping a stopped run: %d, want %d", rec.Code, http.StatusConflict)
+	}
+	if rec := serveAPI("GET", path, ""); !strings.Contains(rec.Body.String(), `"status":"stopped"`) {
+		t.Errorf("stopped run is %s", rec.Body)
+	}
+
+	mu.Lock()
+	shutdown = true
+	mu.Unlock()
+	defer func() {
+		mu.Lock()
+		shutdown = false
+		mu.Unlock()
+	}()
+	if rec := serveAPI("POST", apiRunsPath, `{"target": "idle"}`); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("starting a run on shutdown: %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,70 @@
+// Package backends registers the built-in workload drivers.
+package backends
+
+import "cf-monitoring-demo-app/workload"
+
+func init() {
+	for _, d := range []*workload.Driver{
+		{
+			Name: "mysql", Title: "MySQL", Style: "primary", Env: "MYSQL_URL",
+			Services:    []string{"p-mysql", "p.mysql", "cleardb", "mysql"},
+			ServiceAddr: mysqlAddr,
+			Options:     sqlOptions,
+			New:         newMySQL,
+		},
+		{
+			Name: "pgsql", Title: "PostgreSQL", Style: "success", Env: "PGSQL_URL",
+			Services:    []string{"postgres", "postgresql", "elephantsql"},
+			ServiceAddr: schemelessURL,
+			Options:     sqlOptions,
+			New:         newPGSQL,
+		},
+		{
+			Name: "redis", Title: "Redis", Style: "danger", Env: "REDIS_URL",
+			Services:    []string{"redis", "p-redis", "p.redis", "rediscloud"},
+			ServiceAddr: schemelessURL,
+			Options:     seedOptions,
+			New:         newRedis,
+		},
+		{
+			Name: "memcache", Title: "Memcache", Style: "info", Env: "MEMCACHE_ADDR",
+			Services:    []string{"memcached", "memcachedcloud", "memcache"},
+			ServiceAddr: memcacheAddr,
+			Options:     seedOptions,
+			New:         newMemcache,
+		},
+		{
+			Name: "mongodb", Title: "MongoDB", Style: "warning", Env: "MONGODB_URL",
+			Services:    []string{"mongodb", "mongolab", "mlab"},
+			ServiceAddr: schemelessURL,
+			Options:     seedOptions,
+			New:         newMongoDB,
+		},
+		{
+			Name: "cassandra", Title: "Cassandra", Style: "default", Env: "CASSANDRA_URL",
+			Services:    []string{"cassandra"},
+			ServiceAddr: cassandraAddr,
+			Options:     seedOptions,
+			New:         newCassandra,
+		},
+		{
+			Name: "rabbitmq", Title: "RabbitMQ", Style: "default", Env: "RABBITMQ_URL",
+			Services:    []string{"p-rabbitmq", "p.rabbitmq", "cloudamqp", "rabbitmq"},
+			ServiceAddr: rabbitMQAddr,
+			Options:     rabbitMQOptions,
+			New:         newRabbitMQ,
+		},
+	} {
+		workload.Register(d)
+	}
+}
+
+// contains reports whether s is one of values.
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
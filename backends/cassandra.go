@@ -0,0 +1,108 @@
+package backends
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/gocql/gocql"
+
+	"cf-monitoring-demo-app/vcap"
+	"cf-monitoring-demo-app/workload"
+)
+
+// cassandraDB inserts a row per step or selects and updates preloaded
+// rows, the table is created in the keyspace of the service.
+type cassandraDB struct {
+	cfg  *gocql.ClusterConfig
+	sess *gocql.Session
+
+	seedConfig
+}
+
+// cassandraAddr returns service contact points and keyspace
+// in the host1,host2/keyspace form.
+func cassandraAddr(svc *vcap.Service) (string, error) {
+	hosts := svc.String("node_ips", "contact_points", "hosts", "hostname", "host")
+	if hosts == "" {
+		return "", fmt.Errorf("%s: no contact points in credentials", svc.Name)
+	}
+
+	if ks := svc.String("keyspace_name", "keyspace"); ks != "" {
+		return hosts + "/" + ks, nil
+	}
+	return hosts, nil
+}
+
+func newCassandra() workload.Workload {
+	return &cassandraDB{}
+}
+
+func (c *cassandraDB) Name() string {
+	return "cassandra"
+}
+
+// Configure accepts addresses in the host1,host2/keyspace form.
+func (c *cassandraDB) Configure(cfg workload.Config) error {
+	chunks := strings.SplitN(cfg.Addr, "/", 2)
+	hosts := strings.Split(chunks[0], ",")
+
+	c.cfg = gocql.NewCluster(hosts...)
+	if len(chunks) == 2 {
+		c.cfg.Keyspace = chunks[1]
+	}
+	return c.seedConfig.configure(cfg, 0)
+}
+
+func (c *cassandraDB) Setup(ctx context.Context) error {
+	sess, err := c.cfg.CreateSession()
+	if err != nil {
+		return err
+	}
+	c.sess = sess
+
+	err = workload.Measure(ctx, "CREATE", sess.Query("CREATE TABLE IF NOT EXISTS "+c.ns+" (id int PRIMARY KEY, payload text)").WithContext(ctx).Exec)
+	if err != nil {
+		return err
+	}
+
+	// unlogged batches skip the batch log, records are independent
+	return c.batches(func(from, to int) error {
+		b := sess.NewBatch(gocql.UnloggedBatch).WithContext(ctx)
+		for k := from; k < to; k++ {
+			b.Query("INSERT INTO "+c.ns+" (id, payload) VALUES (?, ?)", k, c.randomPayload())
+		}
+		return workload.Measure(ctx, "SEED", func() error {
+			return sess.ExecuteBatch(b)
+		})
+	})
+}
+
+func (c *cassandraDB) Step(ctx context.Context, i int) error {
+	if c.records != 0 {
+		return c.update(ctx)
+	}
+	return workload.Measure(ctx, "INSERT", c.sess.Query("INSERT INTO "+c.ns+" (id) VALUES (?)", i).Exec)
+}
+
+// update selects a preloaded row and updates another one.
+func (c *cassandraDB) update(ctx context.Context) error {
+	if err := workload.Measure(ctx, "SELECT", func() error {
+		var payload string
+		return c.sess.Query("SELECT payload FROM "+c.ns+" WHERE id = ?", c.key()).WithContext(ctx).Scan(&payload)
+	}); err != nil {
+		return err
+	}
+
+	return workload.Measure(ctx, "UPDATE", c.sess.Query("UPDATE "+c.ns+" SET payload = ? WHERE id = ?",
+		c.randomPayload(), c.key()).WithContext(ctx).Exec)
+}
+
+func (c *cassandraDB) Teardown(ctx context.Context) error {
+	if c.sess == nil {
+		return nil
+	}
+	defer c.sess.Close()
+
+	return workload.Measure(ctx, "DROP", c.sess.Query("DROP TABLE IF EXISTS "+c.ns).WithContext(ctx).Exec)
+}
@@ -0,0 +1,130 @@
+package backends
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/bradfitz/gomemcache/memcache"
+
+	"cf-monitoring-demo-app/vcap"
+	"cf-monitoring-demo-app/workload"
+)
+
+// memcacheDB sets and deletes a key per step or gets and sets preloaded
+// keys, memcached may evict them, so misses aren't errors.
+type memcacheDB struct {
+	addr    string
+	workers int
+	mc      *memcache.Client
+
+	seedConfig
+}
+
+// memcacheAddr returns comma separated servers of the service.
+func memcacheAddr(svc *vcap.Service) (string, error) {
+	if s := svc.String("servers"); s != "" {
+		return s, nil
+	}
+
+	host, port := svc.String("hostname", "host"), svc.String("port")
+	if host == "" || port == "" {
+		return "", fmt.Errorf("%s: neither servers nor hostname and port are in credentials", svc.Name)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+func newMemcache() workload.Workload {
+	return &memcacheDB{}
+}
+
+func (m *memcacheDB) Name() string {
+	return "memcache"
+}
+
+func (m *memcacheDB) Configure(cfg workload.Config) error {
+	m.addr = cfg.Addr
+	m.workers = cfg.Workers
+	return m.seedConfig.configure(cfg, 0)
+}
+
+func (m *memcacheDB) Setup(ctx context.Context) error {
+	m.mc = memcache.New(strings.Split(m.addr, ",")...)
+	m.mc.MaxIdleConns = m.workers
+
+	// there's no multi-set, so a batch is measured as a whole
+	return m.batches(func(from, to int) error {
+		return workload.Measure(ctx, "SEED", func() error {
+			for k := from; k < to; k++ {
+				if err := m.set(m.recordKey(k)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+}
+
+func (m *memcacheDB) set(key string) error {
+	return m.mc.Set(&memcache.Item{
+		Key:   key,
+		Value: []byte(m.randomPayload()),
+	})
+}
+
+func (m *memcacheDB) Step(ctx context.Context, i int) error {
+	if m.records != 0 {
+		return m.update(ctx)
+	}
+
+	key := m.stepKey(i)
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, uint64(i))
+
+	if err := workload.Measure(ctx, "SET", func() error {
+		return m.mc.Set(&memcache.Item{
+			Key:   key,
+			Value: b,
+		})
+	}); err != nil {
+		return err
+	}
+
+	return workload.Measure(ctx, "DELETE", func() error {
+		return m.mc.Delete(key)
+	})
+}
+
+// update gets a preloaded record and sets another one.
+func (m *memcacheDB) update(ctx context.Context) error {
+	if err := workload.Measure(ctx, "GET", func() error {
+		_, err := m.mc.Get(m.recordKey(m.key()))
+		if err == memcache.ErrCacheMiss {
+			return nil
+		}
+		return err
+	}); err != nil {
+		return err
+	}
+
+	return workload.Measure(ctx, "SET", func() error {
+		return m.set(m.recordKey(m.key()))
+	})
+}
+
+func (m *memcacheDB) Teardown(ctx context.Context) error {
+	if m.mc == nil || m.records == 0 {
+		return nil
+	}
+
+	return workload.Measure(ctx, "DELETE", func() error {
+		for k := 0; k < m.records; k++ {
+			if err := m.mc.Delete(m.recordKey(k)); err != nil && err != memcache.ErrCacheMiss {
+				return err
+			}
+		}
+		return nil
+	})
+}
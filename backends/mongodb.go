@@ -0,0 +1,107 @@
+package backends
+
+import (
+	"context"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"cf-monitoring-demo-app/workload"
+)
+
+// mongoDB inserts a document per step or finds and updates preloaded
+// documents, steps use copies of the setup session.
+type mongoDB struct {
+	url string
+	mg  *mgo.Session
+
+	seedConfig
+}
+
+// mongoRecord is a preloaded document.
+type mongoRecord struct {
+	ID      int    `bson:"_id"`
+	Payload string `bson:"payload"`
+}
+
+func newMongoDB() workload.Workload {
+	return &mongoDB{}
+}
+
+func (m *mongoDB) Name() string {
+	return "mongodb"
+}
+
+func (m *mongoDB) Configure(cfg workload.Config) error {
+	m.url = "mongodb://" + cfg.Addr
+	return m.seedConfig.configure(cfg, 0)
+}
+
+func (m *mongoDB) Setup(ctx context.Context) error {
+	mg, err := mgo.Dial(m.url)
+	if err != nil {
+		return err
+	}
+
+	m.mg = mg
+
+	return m.batches(func(from, to int) error {
+		docs := make([]interface{}, 0, to-from)
+		for k := from; k < to; k++ {
+			docs = append(docs, mongoRecord{k, m.randomPayload()})
+		}
+		return workload.Measure(ctx, "SEED", func() error {
+			b := mg.DB("").C(m.ns).Bulk()
+			b.Unordered()
+			b.Insert(docs...)
+			_, err := b.Run()
+			return err
+		})
+	})
+}
+
+// Step uses a session copy so workers don't share a single socket.
+func (m *mongoDB) Step(ctx context.Context, i int) error {
+	mg := m.mg.Copy()
+	defer mg.Close()
+
+	if m.records != 0 {
+		return m.update(ctx, mg.DB("").C(m.ns))
+	}
+
+	return workload.Measure(ctx, "INSERT", func() error {
+		return mg.DB("").C(m.ns).Insert(struct {
+			I int
+		}{i})
+	})
+}
+
+// update finds a preloaded document and updates another one.
+func (m *mongoDB) update(ctx context.Context, c *mgo.Collection) error {
+	if err := workload.Measure(ctx, "FIND", func() error {
+		var doc mongoRecord
+		return c.FindId(m.key()).One(&doc)
+	}); err != nil {
+		return err
+	}
+
+	return workload.Measure(ctx, "UPDATE", func() error {
+		return c.UpdateId(m.key(), bson.M{"$set": bson.M{"payload": m.randomPayload()}})
+	})
+}
+
+func (m *mongoDB) Teardown(ctx context.Context) error {
+	if m.mg == nil {
+		return nil
+	}
+	defer m.mg.Close()
+
+	return workload.Measure(ctx, "DROP", func() error {
+		err := m.mg.DB("").C(m.ns).DropCollection()
+		if qerr, ok := err.(*mgo.QueryError); ok && qerr.Message == "ns not found" {
+			// nothing has been inserted
+			return nil
+		}
+		return err
+	})
+}
@@ -0,0 +1,674 @@
+package backends
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/streadway/amqp"
+
+	"cf-monitoring-demo-app/vcap"
+	"cf-monitoring-demo-app/workload"
+)
+
+const (
+	// rabbitRoutingKey is the routing key prefix, keys are scoped
+	// to the exchange, so they don't need the run namespace
+	rabbitRoutingKey = "cf_monitoring"
+
+	// rabbitKeyHeader carries the routing key index for the headers exchange
+	rabbitKeyHeader = "cf_monitoring-key"
+)
+
+var (
+	errMalformedMessage = errors.New("malformed message")
+	errNacked           = errors.New("message is nacked by the broker")
+	errUnroutable       = errors.New("message is returned as unroutable")
+	errNotConfirmed     = errors.New("channel is closed before the message is confirmed")
+)
+
+var rabbitExchangeTypes = []string{
+	amqp.ExchangeDirect, amqp.ExchangeFanout, amqp.ExchangeTopic, amqp.ExchangeHeaders,
+}
+
+var rabbitMQOptions = []workload.Option{
+	{Name: "durable", Usage: "declare a durable exchange and queues"},
+	{Name: "persistent", Usage: "publish persistent messages"},
+	{Name: "confirm", Usage: "wait for publisher confirms and measure their latency"},
+	{Name: "mandatory", Usage: "publish mandatory messages and count returned ones as errors"},
+	{Name: "consumers", Usage: "number of consumers, defaults to the number of workers or queues, whichever is greater"},
+	{Name: "consume_rate", Usage: "total messages per second consumers are limited to"},
+	{Name: "backlog", Usage: "pause publishing at this total queue depth, it makes queues durable by default"},
+	{Name: "drain_rate", Usage: "drain queues at this rate on teardown, zero is unlimited, queues are deleted with the backlog when it's not set"},
+	{Name: "exchange", Usage: "exchange type: direct, fanout, topic or headers"},
+	{Name: "queues", Usage: "number of queues bound to the exchange"},
+	{Name: "keys", Usage: "number of routing keys messages are spread over"},
+	{Name: "pattern", Usage: "topic exchange binding pattern, defaults to cf_monitoring.#, it must match some of the routing keys"},
+	{Name: "prefetch", Usage: "consumer prefetch count, consumers ack messages manually when it's set"},
+}
+
+// rabbitMQ publishes messages to an exchange in steps while consumers
+// read them from bound queues in background recording the end-to-end
+// latency, every message carries the time it was published at.
+//
+// Messages are published with routing keys cf_monitoring.0 to
+// cf_monitoring.<keys-1> in turns, direct and headers exchanges route
+// every key to a single queue unless there are more queues than keys,
+// fanout and topic ones route every message to all queues.
+//
+// The exchange is named after the run namespace and queues
+// have its name with the -q<index> suffix.
+//
+// Consumers can be slowed down or disabled, so the queue backlog grows,
+// it's reported with the published, consumed and backlog run gauges.
+// The backlog is only drained on teardown when drain_rate is set, the
+// teardown timeout is extended by the time draining it at the rate takes,
+// but not the application shutdown timeout.
+type rabbitMQ struct {
+	// published, routed and consumed are atomic message counters kept
+	// first like the sqlDB ones, routed counts copies delivered to queues
+	published int64
+	routed    int64
+	consumed  int64
+
+	url     string
+	ns      string
+	workers int
+	conn    *amqp.Connection
+
+	durable     bool
+	persistent  bool
+	confirm     bool
+	mandatory   bool
+	consumers   int
+	consumeRate float64
+	backlog     int64
+	drain       bool
+	drainRate   float64
+	exchange    string
+	queues      int
+	keys        int
+	pattern     string
+	prefetch    int
+
+	// copies is the number of queues a message with a routing key index is routed to
+	copies []int64
+
+	// stop interrupts rate limited consumers on teardown
+	stop chan struct{}
+
+	// pubs is a pool of publisher channels, they're not safe for concurrent use.
+	pubs chan *rabbitPub
+
+	consumerChs []*amqp.Channel
+	wg          sync.WaitGroup
+}
+
+// rabbitPub is a publisher channel, confirms are only set in the confirm mode.
+type rabbitPub struct {
+	ch       *amqp.Channel
+	confirms chan amqp.Confirmation
+}
+
+// rabbitMQAddr returns the service amqp uri without the scheme,
+// p-rabbitmq keeps it in the protocols section.
+func rabbitMQAddr(svc *vcap.Service) (string, error) {
+	if p, ok := svc.Credentials["protocols"].(map[string]interface{}); ok {
+		if amqp, ok := p["amqp"].(map[string]interface{}); ok {
+			if uri, ok := amqp["uri"].(string); ok {
+				return strings.TrimPrefix(uri, "amqp://"), nil
+			}
+		}
+	}
+	return schemelessURL(svc)
+}
+
+func newRabbitMQ() workload.Workload {
+	return &rabbitMQ{}
+}
+
+func (r *rabbitMQ) Name() string {
+	return "rabbitmq"
+}
+
+func (r *rabbitMQ) Configure(cfg workload.Config) error {
+	r.url = "amqp://" + cfg.Addr
+	r.ns = cfg.Namespace
+	r.workers = cfg.Workers
+	r.stop = make(chan struct{})
+
+	backlog, err := cfg.Options.Int("backlog", 0)
+	if err != nil {
+		return err
+	}
+	r.backlog = int64(backlog)
+
+	for _, o := range []struct {
+		name string
+		v    *bool
+		d    bool
+	}{
+		{"durable", &r.durable, backlog > 0},
+		{"persistent", &r.persistent, false},
+		{"confirm", &r.confirm, false},
+		{"mandatory", &r.mandatory, false},
+	} {
+		if *o.v, err = cfg.Options.Bool(o.name, o.d); err != nil {
+			return err
+		}
+	}
+
+	r.exchange = cfg.Options.Get("exchange", amqp.ExchangeDirect)
+	r.pattern = cfg.Options.Get("pattern", rabbitRoutingKey+".#")
+	if r.queues, err = cfg.Options.Int("queues", 1); err != nil {
+		return err
+	}
+	if r.keys, err = cfg.Options.Int("keys", 1); err != nil {
+		return err
+	}
+	if r.prefetch, err = cfg.Options.Int("prefetch", 0); err != nil {
+		return err
+	}
+
+	// there's at least a consumer per queue by default
+	consumers := cfg.Workers
+	if consumers < r.queues {
+		consumers = r.queues
+	}
+	if r.consumers, err = cfg.Options.Int("consumers", consumers); err != nil {
+		return err
+	}
+	if r.consumeRate, err = cfg.Options.Float("consume_rate", 0); err != nil {
+		return err
+	}
+	if r.drainRate, err = cfg.Options.Float("drain_rate", 0); err != nil {
+		return err
+	}
+	_, r.drain = cfg.Options["drain_rate"]
+
+	switch {
+	case !contains(rabbitExchangeTypes, r.exchange):
+		return fmt.Errorf("unknown exchange type %q", r.exchange)
+	case r.exchange == amqp.ExchangeTopic && !r.matchesAnyKey():
+		return fmt.Errorf("pattern %q doesn't match any routing key", r.pattern)
+	case r.queues < 1:
+		return errors.New("queues must be positive")
+	case r.keys < 1:
+		return errors.New("keys must be positive")
+	case r.prefetch < 0:
+		return errors.New("prefetch must not be negative")
+	case r.backlog < 0:
+		return errors.New("backlog must not be negative")
+	case r.consumers < 0:
+		return errors.New("consumers must not be negative")
+	case r.consumeRate < 0:
+		return errors.New("consume_rate must not be negative")
+	case r.drainRate < 0:
+		return errors.New("drain_rate must not be negative")
+	}
+	return nil
+}
+
+func (r *rabbitMQ) Setup(ctx context.Context) error {
+	conn, err := amqp.Dial(r.url)
+	if err != nil {
+		return err
+	}
+	r.conn = conn
+
+	if err = r.declare(); err != nil {
+		return err
+	}
+
+	// by default there's a consumer per publisher, so they can keep up,
+	// consumers are spread over queues, rate limited ones ack messages
+	// manually one by one, otherwise the broker would push the backlog to them
+	autoAck := r.consumeRate == 0 && r.prefetch == 0
+	prefetch := r.prefetch
+	if prefetch == 0 {
+		prefetch = 1
+	}
+	for k := 0; k < r.consumers; k++ {
+		ch, err := conn.Channel()
+		if err != nil {
+			return err
+		}
+		r.consumerChs = append(r.consumerChs, ch)
+
+		if !autoAck {
+			if err = ch.Qos(prefetch, 0, false); err != nil {
+				return err
+			}
+		}
+
+		msgs, err := ch.Consume(r.queueName(k%r.queues), consumerTag(k), autoAck, false, false, false, nil)
+		if err != nil {
+			return err
+		}
+
+		r.wg.Add(1)
+		go r.consume(ctx, msgs, autoAck, newPacer(r.consumeRate/float64(r.consumers)))
+	}
+
+	r.pubs = make(chan *rabbitPub, r.workers)
+	for k := 0; k < r.workers; k++ {
+		p, err := r.publisher(ctx)
+		if err != nil {
+			return err
+		}
+		r.pubs <- p
+	}
+	return nil
+}
+
+// publisher opens a publisher channel and puts it in the confirm mode
+// and starts recording returned messages when it's needed.
+func (r *rabbitMQ) publisher(ctx context.Context) (*rabbitPub, error) {
+	ch, err := r.conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &rabbitPub{ch: ch}
+	if r.confirm {
+		if err = ch.Confirm(false); err != nil {
+			return nil, err
+		}
+		// a step waits for its confirm, so there's at most one pending
+		p.confirms = ch.NotifyPublish(make(chan amqp.Confirmation, 1))
+	}
+	if r.mandatory {
+		// the returns channel is closed along with the amqp channel
+		returns := ch.NotifyReturn(make(chan amqp.Return, 1))
+		go func() {
+			for range returns {
+				workload.Record(ctx, "return", 0, errUnroutable)
+			}
+		}()
+	}
+	return p, nil
+}
+
+// declare declares the exchange and queues and binds them.
+func (r *rabbitMQ) declare() error {
+	ch, err := r.conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	if err = ch.ExchangeDeclare(r.ns, r.exchange, r.durable, !r.durable, false, false, nil); err != nil {
+		return err
+	}
+
+	for q := 0; q < r.queues; q++ {
+		// a transient queue is exclusive, so it's deleted along with the
+		// connection, a durable one survives broker restarts until teardown
+		if _, err = ch.QueueDeclare(r.queueName(q), r.durable, false, !r.durable, false, nil); err != nil {
+			return err
+		}
+	}
+
+	r.copies = make([]int64, r.keys)
+	switch r.exchange {
+	case amqp.ExchangeFanout, amqp.ExchangeTopic:
+		key := ""
+		if r.exchange == amqp.ExchangeTopic {
+			key = r.pattern
+		}
+		for q := 0; q < r.queues; q++ {
+			if err = ch.QueueBind(r.queueName(q), key, r.ns, false, nil); err != nil {
+				return err
+			}
+		}
+
+		// keys the topic pattern doesn't match aren't routed
+		for k := range r.copies {
+			if r.exchange == amqp.ExchangeFanout || topicMatch(r.pattern, routingKey(k)) {
+				r.copies[k] = int64(r.queues)
+			}
+		}
+	default:
+		n := r.keys
+		if n < r.queues {
+			n = r.queues
+		}
+		for i := 0; i < n; i++ {
+			q, k := i%r.queues, i%r.keys
+
+			var args amqp.Table
+			if r.exchange == amqp.ExchangeHeaders {
+				args = amqp.Table{"x-match": "all", rabbitKeyHeader: strconv.Itoa(k)}
+			}
+			if err = ch.QueueBind(r.queueName(q), routingKey(k), r.ns, false, args); err != nil {
+				return err
+			}
+			r.copies[k]++
+		}
+	}
+	return nil
+}
+
+func (r *rabbitMQ) queueName(q int) string {
+	return r.ns + "-q" + strconv.Itoa(q)
+}
+
+func routingKey(k int) string {
+	return rabbitRoutingKey + "." + strconv.Itoa(k)
+}
+
+// matchesAnyKey reports whether the topic pattern matches a routing key.
+func (r *rabbitMQ) matchesAnyKey() bool {
+	for k := 0; k < r.keys; k++ {
+		if topicMatch(r.pattern, routingKey(k)) {
+			return true
+		}
+	}
+	return false
+}
+
+// topicMatch reports whether a topic exchange binding pattern matches
+// the routing key, * matches a single word and # matches zero or more.
+func topicMatch(pattern, key string) bool {
+	return matchWords(strings.Split(pattern, "."), strings.Split(key, "."))
+}
+
+func matchWords(p, k []string) bool {
+	for len(p) != 0 {
+		if p[0] == "#" {
+			for i := 0; i <= len(k); i++ {
+				if matchWords(p[1:], k[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(k) == 0 || p[0] != "*" && p[0] != k[0] {
+			return false
+		}
+		p, k = p[1:], k[1:]
+	}
+	return len(k) == 0
+}
+
+func consumerTag(k int) string {
+	return "cf_monitoring-c" + strconv.Itoa(k)
+}
+
+// consume records end-to-end latencies of msgs until the channel is closed,
+// manually acked messages are acked in the pace, unacked ones are
+// requeued when the consumer is stopped and its channel is closed.
+//
+// Once stopped, it keeps reading msgs without acking until they're closed
+// by the consumer cancellation, the client blocks delivering them otherwise.
+func (r *rabbitMQ) consume(ctx context.Context, msgs <-chan amqp.Delivery, autoAck bool, p *pacer) {
+	defer r.wg.Done()
+
+	for m := range msgs {
+		if !autoAck {
+			if !p.wait(r.stop) {
+				continue
+			}
+			if err := m.Ack(false); err != nil {
+				workload.Record(ctx, "consume", 0, err)
+				continue
+			}
+		}
+		r.received(ctx, "consume", m.Body)
+	}
+}
+
+// received records the end-to-end latency of a consumed message as op.
+func (r *rabbitMQ) received(ctx context.Context, op string, body []byte) {
+	n := atomic.AddInt64(&r.consumed, 1)
+	workload.SetGauge(ctx, "consumed", n)
+	workload.SetGauge(ctx, "backlog", atomic.LoadInt64(&r.routed)-n)
+
+	if len(body) < 8 {
+		workload.Record(ctx, op, 0, errMalformedMessage)
+		return
+	}
+
+	sent := time.Unix(0, int64(binary.BigEndian.Uint64(body)))
+	workload.Record(ctx, op, time.Since(sent), nil)
+}
+
+// pacer spaces events out evenly, nil doesn't limit the rate.
+type pacer struct {
+	interval time.Duration
+	next     time.Time
+}
+
+func newPacer(rate float64) *pacer {
+	if rate <= 0 {
+		return nil
+	}
+	return &pacer{interval: time.Duration(float64(time.Second) / rate)}
+}
+
+// wait blocks until the next event is due, it returns false when stop is closed.
+func (p *pacer) wait(stop <-chan struct{}) bool {
+	if p == nil {
+		select {
+		case <-stop:
+			return false
+		default:
+			return true
+		}
+	}
+
+	now := time.Now()
+	if p.next.Before(now) {
+		p.next = now
+	}
+	t := time.NewTimer(p.next.Sub(now))
+	defer t.Stop()
+	p.next = p.next.Add(p.interval)
+
+	select {
+	case <-t.C:
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+func (r *rabbitMQ) Step(ctx context.Context, i int) error {
+	if !r.waitBacklog(ctx) {
+		return nil
+	}
+
+	p := <-r.pubs
+	defer func() {
+		r.pubs <- p
+	}()
+
+	// the body is the publishing time followed by the step number
+	body := strconv.AppendInt(make([]byte, 8, 32), int64(i), 10)
+
+	k := i % r.keys
+	var headers amqp.Table
+	if r.exchange == amqp.ExchangeHeaders {
+		headers = amqp.Table{rabbitKeyHeader: strconv.Itoa(k)}
+	}
+
+	mode := amqp.Transient
+	if r.persistent {
+		mode = amqp.Persistent
+	}
+
+	err := workload.Measure(ctx, "publish", func() error {
+		now := time.Now()
+		binary.BigEndian.PutUint64(body, uint64(now.UnixNano()))
+
+		return p.ch.Publish(r.ns, routingKey(k), r.mandatory, false, amqp.Publishing{
+			Headers:      headers,
+			ContentType:  "application/octet-stream",
+			DeliveryMode: mode,
+			Timestamp:    now,
+			Body:         body,
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	workload.SetGauge(ctx, "published", atomic.AddInt64(&r.published, 1))
+	routed := atomic.AddInt64(&r.routed, r.copies[k])
+	workload.SetGauge(ctx, "backlog", routed-atomic.LoadInt64(&r.consumed))
+	if p.confirms == nil {
+		return nil
+	}
+
+	start := time.Now()
+	select {
+	case c, ok := <-p.confirms:
+		switch {
+		case !ok:
+			err = errNotConfirmed
+		case !c.Ack:
+			err = errNacked
+		}
+		workload.Record(ctx, "confirm", time.Since(start), err)
+		return err
+	case <-ctx.Done():
+		// the run is over, the confirm doesn't matter anymore
+		return nil
+	}
+}
+
+// waitBacklog blocks while the estimated backlog is at its limit,
+// it returns false when ctx is done first.
+func (r *rabbitMQ) waitBacklog(ctx context.Context) bool {
+	if r.backlog == 0 {
+		return true
+	}
+
+	for atomic.LoadInt64(&r.routed)-atomic.LoadInt64(&r.consumed) >= r.backlog {
+		select {
+		case <-time.After(10 * time.Millisecond):
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
+
+// Teardown cancels consumers, waits until they're done with delivered
+// messages, drains queues when it's asked to, deletes queues and
+// the exchange and closes the connection, that closes all channels.
+//
+// Queues and the exchange are deleted even when consumers can't be
+// cancelled, durable ones would outlive the run with the backlog otherwise.
+func (r *rabbitMQ) Teardown(ctx context.Context) error {
+	if r.conn == nil {
+		return nil
+	}
+	defer r.conn.Close()
+
+	close(r.stop)
+	var cerr error
+	for k, ch := range r.consumerChs {
+		if err := ch.Cancel(consumerTag(k), false); err != nil && cerr == nil {
+			cerr = err
+		}
+	}
+
+	conn := r.conn
+	if cerr != nil {
+		// delivery channels are closed along with the connection,
+		// so the cleanup needs a fresh one
+		r.conn.Close()
+		r.wg.Wait()
+
+		var err error
+		if conn, err = amqp.Dial(r.url); err != nil {
+			return fmt.Errorf("%v, cleanup: %v", cerr, err)
+		}
+		defer conn.Close()
+	} else {
+		r.wg.Wait()
+
+		// closing consumer channels requeues messages they haven't acked
+		for _, ch := range r.consumerChs {
+			ch.Close()
+		}
+	}
+
+	err := r.cleanup(ctx, conn)
+	switch {
+	case cerr != nil && err != nil:
+		return fmt.Errorf("%v, cleanup: %v", cerr, err)
+	case cerr != nil:
+		return cerr
+	}
+	return err
+}
+
+// cleanup drains queues when it's asked to and deletes queues and the exchange.
+func (r *rabbitMQ) cleanup(ctx context.Context, conn *amqp.Connection) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	if r.drain {
+		err = r.drainQueues(ctx, ch)
+	}
+
+	derr := workload.Measure(ctx, "delete", func() error {
+		for q := 0; q < r.queues; q++ {
+			if _, err := ch.QueueDelete(r.queueName(q), false, false, false); err != nil {
+				return err
+			}
+		}
+		return ch.ExchangeDelete(r.ns, false, false)
+	})
+	if err != nil {
+		return err
+	}
+	return derr
+}
+
+// TeardownExtra returns the time the estimated backlog takes to drain.
+func (r *rabbitMQ) TeardownExtra() time.Duration {
+	if !r.drain || r.drainRate == 0 {
+		return 0
+	}
+
+	n := atomic.LoadInt64(&r.routed) - atomic.LoadInt64(&r.consumed)
+	if n <= 0 {
+		return 0
+	}
+	return time.Duration(float64(n) / r.drainRate * float64(time.Second))
+}
+
+// drainQueues gets messages from queues one by one in the drain rate pace
+// until they're empty, queues are deleted anyway, so it gives up when ctx is done.
+func (r *rabbitMQ) drainQueues(ctx context.Context, ch *amqp.Channel) error {
+	p := newPacer(r.drainRate)
+	for q := 0; q < r.queues; q++ {
+		for {
+			if !p.wait(ctx.Done()) {
+				return ctx.Err()
+			}
+
+			m, ok, err := ch.Get(r.queueName(q), true)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				break
+			}
+			r.received(ctx, "drain", m.Body)
+		}
+	}
+	return nil
+}
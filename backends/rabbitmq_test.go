@@ -0,0 +1,26 @@
+package backends
+
+import "testing"
+
+func TestTopicMatch(t *testing.T) {
+	for _, c := range []struct {
+		pattern string
+		key     string
+		match   bool
+	}{
+		{"cf_monitoring.#", "cf_monitoring.1", true},
+		{"#", "cf_monitoring.1", true},
+		{"cf_monitoring.*", "cf_monitoring.1", true},
+		{"cf_monitoring.1", "cf_monitoring.1", true},
+		{"cf_monitoring.#.1", "cf_monitoring.1", true},
+		{"#.1.#", "cf_monitoring.1", true},
+		{"*", "cf_monitoring.1", false},
+		{"cf_monitoring.2", "cf_monitoring.1", false},
+		{"cf_monitoring.1.*", "cf_monitoring.1", false},
+		{"other.#", "cf_monitoring.1", false},
+	} {
+		if got := topicMatch(c.pattern, c.key); got != c.match {
+			t.Errorf("topicMatch(%q, %q) = %t, want %t", c.pattern, c.key, got, c.match)
+		}
+	}
+}
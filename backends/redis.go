@@ -0,0 +1,132 @@
+package backends
+
+import (
+	"context"
+
+	"github.com/garyburd/redigo/redis"
+
+	"cf-monitoring-demo-app/workload"
+)
+
+// redisDB sets and deletes a key per step or gets and sets preloaded keys,
+// the pool keeps an idle connection per worker.
+type redisDB struct {
+	url     string
+	workers int
+	pool    *redis.Pool
+
+	seedConfig
+}
+
+func newRedis() workload.Workload {
+	return &redisDB{}
+}
+
+func (r *redisDB) Name() string {
+	return "redis"
+}
+
+func (r *redisDB) Configure(cfg workload.Config) error {
+	r.url = "redis://" + cfg.Addr
+	r.workers = cfg.Workers
+	return r.seedConfig.configure(cfg, 0)
+}
+
+func (r *redisDB) Setup(ctx context.Context) error {
+	r.pool = &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return redis.DialURL(r.url)
+		},
+		MaxIdle: r.workers,
+	}
+
+	// fail fast when the server is unreachable
+	conn := r.pool.Get()
+	defer conn.Close()
+	if err := conn.Err(); err != nil {
+		return err
+	}
+
+	return r.batches(func(from, to int) error {
+		args := make([]interface{}, 0, (to-from)*2)
+		for k := from; k < to; k++ {
+			args = append(args, r.recordKey(k), r.randomPayload())
+		}
+		return workload.Measure(ctx, "SEED", func() error {
+			_, err := conn.Do("MSET", args...)
+			return err
+		})
+	})
+}
+
+func (r *redisDB) Step(ctx context.Context, i int) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	if r.records != 0 {
+		return r.update(ctx, conn)
+	}
+
+	key := r.stepKey(i)
+
+	if err := workload.Measure(ctx, "SET", func() error {
+		_, err := conn.Do("SET", key, i)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	return workload.Measure(ctx, "DEL", func() error {
+		_, err := conn.Do("DEL", key)
+		return err
+	})
+}
+
+// update gets a preloaded record and sets another one.
+func (r *redisDB) update(ctx context.Context, conn redis.Conn) error {
+	if err := workload.Measure(ctx, "GET", func() error {
+		_, err := conn.Do("GET", r.recordKey(r.key()))
+		return err
+	}); err != nil {
+		return err
+	}
+
+	return workload.Measure(ctx, "SET", func() error {
+		_, err := conn.Do("SET", r.recordKey(r.key()), r.randomPayload())
+		return err
+	})
+}
+
+func (r *redisDB) Teardown(ctx context.Context) error {
+	if r.pool == nil {
+		return nil
+	}
+	defer r.pool.Close()
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	// keys of interrupted steps are left behind too,
+	// so all keys of the namespace are scanned for
+	return workload.Measure(ctx, "DEL", func() error {
+		cursor := "0"
+		for {
+			res, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", r.ns+"-*", "COUNT", seedBatch))
+			if err != nil {
+				return err
+			}
+			var keys []interface{}
+			if _, err = redis.Scan(res, &cursor, &keys); err != nil {
+				return err
+			}
+			if len(keys) != 0 {
+				if _, err = conn.Do("DEL", keys...); err != nil {
+					return err
+				}
+			}
+			if cursor == "0" {
+				return nil
+			}
+		}
+	})
+}
@@ -0,0 +1,108 @@
+package backends
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+
+	"cf-monitoring-demo-app/workload"
+)
+
+// seedOptions are options of drivers that preload records in setup.
+var seedOptions = []workload.Option{
+	{Name: "records", Usage: "number of records preloaded in setup, reads and updates pick them"},
+	{Name: "payload", Usage: "record payload size in bytes"},
+	{Name: "distribution", Usage: "key distribution of reads and updates: uniform, zipf[:s] or hotspot[:fraction[:probability]]"},
+}
+
+// seedBatch is the number of records written by a single seeding request.
+const seedBatch = 100
+
+// seedConfig is the preloaded data set configuration.
+//
+// Workloads write fresh records per step unless records are preloaded,
+// then they read and update ones picked with the key distribution instead.
+// Keys, tables and collections are named after the run namespace,
+// so that runs don't see each other's data.
+type seedConfig struct {
+	// ns is the run namespace keys are prefixed with
+	ns string
+
+	records int
+	payload int
+	dist    workload.Distribution
+}
+
+// configure reads the namespace and seed options,
+// records is the default number of records.
+func (c *seedConfig) configure(cfg workload.Config, records int) error {
+	c.ns = cfg.Namespace
+
+	o := cfg.Options
+	var err error
+	if c.records, err = o.Int("records", records); err != nil {
+		return err
+	}
+	if c.payload, err = o.Int("payload", 100); err != nil {
+		return err
+	}
+	if c.dist, err = workload.ParseDistribution(o.Get("distribution", "")); err != nil {
+		return err
+	}
+
+	switch {
+	case c.records < 0:
+		return errors.New("records must not be negative")
+	case c.payload < 0 || c.payload > len(payloads)/2:
+		return fmt.Errorf("payload must be between 0 and %d", len(payloads)/2)
+	}
+	return nil
+}
+
+// key picks a preloaded record key.
+func (c *seedConfig) key() int {
+	return int(c.dist(int64(c.records)))
+}
+
+// randomPayload returns a random string of the configured size.
+func (c *seedConfig) randomPayload() string {
+	off := rand.Intn(len(payloads) - c.payload + 1)
+	return payloads[off : off+c.payload]
+}
+
+// batches calls fn with consecutive key ranges of at most
+// seedBatch records covering all records until it fails.
+func (c *seedConfig) batches(fn func(from, to int) error) error {
+	for from := 0; from < c.records; from += seedBatch {
+		to := from + seedBatch
+		if to > c.records {
+			to = c.records
+		}
+		if err := fn(from, to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stepKey is the key step i writes in key-value stores.
+func (c *seedConfig) stepKey(i int) string {
+	return c.ns + "-" + strconv.Itoa(i)
+}
+
+// recordKey is the key preloaded record k is stored under in key-value stores.
+func (c *seedConfig) recordKey(k int) string {
+	return c.ns + "-r" + strconv.Itoa(k)
+}
+
+// payloads is the source of payloads, they're its random substrings.
+var payloads = func() string {
+	const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+	b := make([]byte, 1<<17)
+	for i := range b {
+		b[i] = letters[rand.Intn(len(letters))]
+	}
+	return string(b)
+}()
@@ -0,0 +1,794 @@
+package backends
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+
+	"cf-monitoring-demo-app/vcap"
+	"cf-monitoring-demo-app/workload"
+)
+
+// mysqlSchema and pgsqlSchema are formatted with the main and the hot table names.
+var mysqlSchema = []string{
+	`CREATE TABLE IF NOT EXISTS %[1]s (
+		id BIGINT PRIMARY KEY,
+		k INT NOT NULL,
+		tag VARCHAR(32) NOT NULL,
+		payload TEXT NOT NULL,
+		INDEX %[1]s_k (k),
+		INDEX %[1]s_tag (tag)
+	)`,
+	sqlHotSchema,
+}
+
+var pgsqlSchema = []string{
+	`CREATE TABLE IF NOT EXISTS %[1]s (
+		id BIGINT PRIMARY KEY,
+		k INT NOT NULL,
+		tag VARCHAR(32) NOT NULL,
+		payload TEXT NOT NULL
+	)`,
+	"CREATE INDEX IF NOT EXISTS %[1]s_k ON %[1]s (k)",
+	"CREATE INDEX IF NOT EXISTS %[1]s_tag ON %[1]s (tag)",
+	sqlHotSchema,
+}
+
+// sqlHotSchema is a table of a few rows that transactions contend on.
+const sqlHotSchema = "CREATE TABLE IF NOT EXISTS %[2]s (id INT PRIMARY KEY, n BIGINT NOT NULL)"
+
+// sqlIsolation maps isolation option values to database/sql levels
+// and MySQL isolation level names, the MySQL driver doesn't support
+// isolation levels in BeginTx, so they're set with SET TRANSACTION.
+var sqlIsolation = map[string]struct {
+	level sql.IsolationLevel
+	mysql string
+}{
+	"read_committed":  {sql.LevelReadCommitted, "READ COMMITTED"},
+	"repeatable_read": {sql.LevelRepeatableRead, "REPEATABLE READ"},
+	"serializable":    {sql.LevelSerializable, "SERIALIZABLE"},
+}
+
+// sqlConflicts are gauges transaction conflicts are counted under.
+var sqlConflicts = []string{"deadlocks", "serialization_failures", "lock_timeouts"}
+
+// sqlOps are step operations in the order their weights are looked up.
+var sqlOps = []struct {
+	name   string
+	weight int // default weight
+}{
+	{"select", 50},
+	{"range", 10},
+	{"update", 20},
+	{"delete", 5},
+	{"insert", 15},
+	{"txn", 0},
+	{"hot", 0},
+	{"deadlock", 0},
+	{"idle", 0},
+	{"churn", 0},
+	{"bulk", 0},
+}
+
+var sqlOptions = append([]workload.Option{
+	{Name: "select", Usage: "weight of point SELECTs by the primary key"},
+	{Name: "range", Usage: "weight of range scans over the indexed column"},
+	{Name: "update", Usage: "weight of UPDATEs by the primary key"},
+	{Name: "delete", Usage: "weight of DELETEs by the primary key"},
+	{Name: "insert", Usage: "weight of INSERTs"},
+	{Name: "txn", Usage: "weight of transactions locking, updating and inserting rows"},
+	{Name: "hot", Usage: "weight of transactions updating hot rows and holding locks"},
+	{Name: "deadlock", Usage: "weight of transactions locking two hot rows in alternating order"},
+	{Name: "idle", Usage: "weight of transactions staying idle before the commit"},
+	{Name: "churn", Usage: "weight of connecting, pinging and disconnecting"},
+	{Name: "bulk", Usage: "weight of bulk writes of batches of rows"},
+	{Name: "range_size", Usage: "width of range scans over the indexed column"},
+	{Name: "hot_rows", Usage: "number of rows hot transactions contend on"},
+	{Name: "hold", Usage: "how long hot and deadlock transactions hold locks, e.g. 10ms"},
+	{Name: "idle_time", Usage: "how long idle transactions stay open, e.g. 5s"},
+	{Name: "isolation", Usage: "transaction isolation: read_committed, repeatable_read or serializable"},
+	{Name: "max_open", Usage: "maximum number of open pool connections, zero is unlimited"},
+	{Name: "max_idle", Usage: "maximum number of idle pool connections, defaults to the number of workers"},
+	{Name: "max_lifetime", Usage: "maximum pool connection lifetime, e.g. 1m, zero is unlimited"},
+	{Name: "conns", Usage: "number of connections opened in setup and held until teardown"},
+	{Name: "batch", Usage: "number of rows written by a bulk write"},
+	{Name: "bulk_mode", Usage: "bulk write mode: values, txn or copy"},
+	{Name: "statements", Usage: "statement mode: params, prepared, adhoc or interpolate (MySQL only)"},
+	{Name: "slow_rate", Usage: "expensive queries per second issued in background, zero disables them"},
+	{Name: "slow_workers", Usage: "maximum number of expensive queries running at once"},
+	{Name: "slow_scan", Usage: "weight of unindexed scans"},
+	{Name: "slow_sort", Usage: "weight of large sorts"},
+	{Name: "slow_join", Usage: "weight of cartesian joins"},
+	{Name: "slow_sleep", Usage: "weight of server side sleeps"},
+	{Name: "join_rows", Usage: "number of rows on each side of cartesian joins"},
+	{Name: "sleep_time", Usage: "duration of server side sleeps, e.g. 1s"},
+}, seedOptions...)
+
+const (
+	// sqlKeys is the number of distinct indexed column values
+	sqlKeys = 10000
+
+	// sqlTags is the number of distinct tags
+	sqlTags = 16
+)
+
+// sqlDB runs a weighted mix of point and range reads and writes against
+// a table with a primary key, indexed columns and a text payload.
+//
+// Rows are identified by sequential ids assigned by the workload,
+// so point operations mostly hit existing rows.
+//
+// Held connections and the connection churn use their own pools,
+// so they don't interfere with the workload pool limits.
+//
+// Tables are named after the run namespace, the main one has its name
+// and the hot one has the _hot suffix.
+//
+// Transactional operations contend on a separate table of hot rows,
+// deadlocks, serialization failures and lock timeouts they run into
+// are expected, they're counted as errors and reported as run gauges
+// and don't fail the run.
+type sqlDB struct {
+	// maxID is the last assigned id, bulkRows and bulkBytes are
+	// counters of bulk writes, they're accessed atomically and
+	// they're first to be 64-bit aligned
+	maxID     int64
+	bulkRows  int64
+	bulkBytes int64
+
+	name    string
+	driver  string
+	prefix  string
+	url     string
+	workers int
+	db      *sql.DB
+
+	schema   []string // formatted with table and hotTable
+	table    string
+	hotTable string
+	numbered bool // placeholders are $1, $2, ... instead of ?
+
+	seedConfig
+
+	weights   []int
+	total     int
+	rangeSize int
+	hotRows   int
+	hold      time.Duration
+	idleTime  time.Duration
+	txOptions *sql.TxOptions
+	isolation string // MySQL isolation level of transactions
+
+	maxOpen     int
+	maxIdle     int
+	maxLifetime time.Duration
+	conns       int
+	holdDB      *sql.DB
+	held        []*sql.Conn
+	churnDB     *sql.DB
+
+	batch    int
+	bulkMode string
+
+	// started is when the setup is done
+	started time.Time
+
+	statements string
+	stmtsMu    sync.Mutex
+	stmts      map[string]*sql.Stmt
+
+	slowRate    float64
+	slowWorkers int
+	slowWeights []int
+	joinRows    int
+	sleepTime   time.Duration
+	slowWG      sync.WaitGroup
+
+	// slowStop stops slow queries, they outlive failed runs otherwise,
+	// since the setup context is only done when the run duration is over
+	slowStop context.CancelFunc
+
+	// conflicts are counters of sqlConflicts accessed atomically
+	conflicts map[string]*int64
+}
+
+// mysqlAddr converts service credentials to a DSN, the uri credential
+// can't be used as is since it's not understood by the driver.
+func mysqlAddr(svc *vcap.Service) (string, error) {
+	u, err := serviceURL(svc)
+	if err != nil {
+		return "", err
+	}
+
+	pass, _ := u.User.Password()
+	return fmt.Sprintf("%s:%s@tcp(%s)%s", u.User.Username(), pass, u.Host, u.Path), nil
+}
+
+func newMySQL() workload.Workload {
+	return &sqlDB{name: "mysql", driver: "mysql", schema: mysqlSchema}
+}
+
+func newPGSQL() workload.Workload {
+	return &sqlDB{name: "pgsql", driver: "postgres", prefix: "postgres://", schema: pgsqlSchema, numbered: true}
+}
+
+func (s *sqlDB) Name() string {
+	return s.name
+}
+
+func (s *sqlDB) Configure(cfg workload.Config) error {
+	s.url = s.prefix + cfg.Addr
+	s.table = cfg.Namespace
+	s.hotTable = cfg.Namespace + "_hot"
+	s.workers = cfg.Workers
+
+	s.weights = make([]int, len(sqlOps))
+	for i, op := range sqlOps {
+		w, err := cfg.Options.Int(op.name, op.weight)
+		if err != nil {
+			return err
+		}
+		if w < 0 {
+			return fmt.Errorf("%s weight must not be negative", op.name)
+		}
+		s.weights[i] = w
+		s.total += w
+	}
+	var err error
+	if s.conns, err = cfg.Options.Int("conns", 0); err != nil {
+		return err
+	}
+	if s.total == 0 && s.conns == 0 {
+		return errors.New("at least one operation weight or conns must be positive")
+	}
+
+	if err = s.seedConfig.configure(cfg, 1000); err != nil {
+		return err
+	}
+	if s.rangeSize, err = cfg.Options.Int("range_size", 100); err != nil {
+		return err
+	}
+	if s.hotRows, err = cfg.Options.Int("hot_rows", 2); err != nil {
+		return err
+	}
+	if s.hold, err = cfg.Options.Duration("hold", 10*time.Millisecond); err != nil {
+		return err
+	}
+	if s.idleTime, err = cfg.Options.Duration("idle_time", 5*time.Second); err != nil {
+		return err
+	}
+	if s.maxOpen, err = cfg.Options.Int("max_open", 0); err != nil {
+		return err
+	}
+	if s.maxIdle, err = cfg.Options.Int("max_idle", cfg.Workers); err != nil {
+		return err
+	}
+	if s.maxLifetime, err = cfg.Options.Duration("max_lifetime", 0); err != nil {
+		return err
+	}
+	if s.batch, err = cfg.Options.Int("batch", 100); err != nil {
+		return err
+	}
+	s.bulkMode = cfg.Options.Get("bulk_mode", "values")
+
+	if s.slowRate, err = cfg.Options.Float("slow_rate", 0); err != nil {
+		return err
+	}
+	if s.slowWorkers, err = cfg.Options.Int("slow_workers", 2); err != nil {
+		return err
+	}
+	if s.joinRows, err = cfg.Options.Int("join_rows", 1000); err != nil {
+		return err
+	}
+	if s.sleepTime, err = cfg.Options.Duration("sleep_time", time.Second); err != nil {
+		return err
+	}
+	slowTotal := 0
+	s.slowWeights = make([]int, len(sqlSlowQueries))
+	for i, q := range sqlSlowQueries {
+		w, err := cfg.Options.Int(q.name, q.weight)
+		if err != nil {
+			return err
+		}
+		if w < 0 {
+			return fmt.Errorf("%s weight must not be negative", q.name)
+		}
+		s.slowWeights[i] = w
+		slowTotal += w
+	}
+
+	s.statements = cfg.Options.Get("statements", "params")
+	s.stmts = map[string]*sql.Stmt{}
+	if s.statements == "interpolate" {
+		if s.driver != "mysql" {
+			return fmt.Errorf("statement mode %q is only supported by mysql", s.statements)
+		}
+		s.dsnParam("interpolateParams", "true")
+	}
+
+	if name := cfg.Options.Get("isolation", ""); name != "" {
+		iso, ok := sqlIsolation[name]
+		if !ok {
+			return fmt.Errorf("unknown isolation %q", name)
+		}
+		if s.driver == "mysql" {
+			s.isolation = iso.mysql
+		} else {
+			s.txOptions = &sql.TxOptions{Isolation: iso.level}
+		}
+	}
+
+	s.conflicts = map[string]*int64{}
+	for _, c := range sqlConflicts {
+		s.conflicts[c] = new(int64)
+	}
+
+	switch {
+	case s.rangeSize < 1:
+		return errors.New("range_size must be positive")
+	case s.hotRows < 2:
+		return errors.New("hot_rows must be at least 2")
+	case s.hold < 0 || s.idleTime < 0:
+		return errors.New("hold and idle_time must not be negative")
+	case s.maxOpen < 0 || s.maxIdle < 0 || s.maxLifetime < 0 || s.conns < 0:
+		return errors.New("max_open, max_idle, max_lifetime and conns must not be negative")
+	case s.batch < 1:
+		return errors.New("batch must be positive")
+	case !contains(sqlBulkModes, s.bulkMode):
+		return fmt.Errorf("unknown bulk mode %q", s.bulkMode)
+	case !contains(sqlStatementModes, s.statements):
+		return fmt.Errorf("unknown statement mode %q", s.statements)
+	case s.slowRate < 0:
+		return errors.New("slow_rate must not be negative")
+	case s.slowRate > 0 && slowTotal == 0:
+		return errors.New("at least one slow query weight must be positive")
+	case s.slowWorkers < 1 || s.joinRows < 1 || s.sleepTime < 0:
+		return errors.New("slow_workers and join_rows must be positive and sleep_time must not be negative")
+	}
+	return nil
+}
+
+// dsnParam adds a MySQL DSN parameter, v must be query escaped.
+func (s *sqlDB) dsnParam(k, v string) {
+	sep := "?"
+	if strings.Contains(s.url, "?") {
+		sep = "&"
+	}
+	s.url += sep + k + "=" + v
+}
+
+func (s *sqlDB) Setup(ctx context.Context) error {
+	db, err := sql.Open(s.driver, s.url)
+	if err != nil {
+		return err
+	}
+	db.SetMaxOpenConns(s.maxOpen)
+	db.SetMaxIdleConns(s.maxIdle)
+	db.SetConnMaxLifetime(s.maxLifetime)
+	s.db = db
+
+	err = workload.Measure(ctx, "CREATE", func() error {
+		for _, q := range s.schema {
+			if _, err := db.ExecContext(ctx, fmt.Sprintf(q, s.table, s.hotTable)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, c := range sqlConflicts {
+		workload.SetGauge(ctx, c, 0)
+	}
+	if err = s.seedHot(ctx); err != nil {
+		return err
+	}
+	if err = s.seed(ctx); err != nil {
+		return err
+	}
+
+	if s.weight("churn") != 0 {
+		// connections aren't kept idle, so every churn op reconnects
+		if s.churnDB, err = sql.Open(s.driver, s.url); err != nil {
+			return err
+		}
+		s.churnDB.SetMaxIdleConns(0)
+	}
+	if err = s.holdConns(ctx); err != nil {
+		return err
+	}
+
+	s.started = time.Now()
+	if s.slowRate > 0 {
+		ctx, s.slowStop = context.WithCancel(ctx)
+		s.slowWG.Add(1)
+		go s.slowQueries(ctx)
+	}
+	return nil
+}
+
+// weight returns the weight of the named operation.
+func (s *sqlDB) weight(op string) int {
+	for k, o := range sqlOps {
+		if o.name == op {
+			return s.weights[k]
+		}
+	}
+	return 0
+}
+
+// holdConns opens the configured number of connections and keeps them until teardown.
+func (s *sqlDB) holdConns(ctx context.Context) error {
+	if s.conns == 0 {
+		return nil
+	}
+
+	var err error
+	if s.holdDB, err = sql.Open(s.driver, s.url); err != nil {
+		return err
+	}
+	for len(s.held) < s.conns {
+		err = workload.Measure(ctx, "HOLD", func() error {
+			conn, err := s.holdDB.Conn(ctx)
+			if err != nil {
+				return err
+			}
+			if err = conn.PingContext(ctx); err != nil {
+				conn.Close()
+				return err
+			}
+			s.held = append(s.held, conn)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		workload.SetGauge(ctx, "held_connections", int64(len(s.held)))
+	}
+	return nil
+}
+
+// seedHot inserts hot rows in a single statement.
+func (s *sqlDB) seedHot(ctx context.Context) error {
+	var b strings.Builder
+	args := make([]interface{}, 0, s.hotRows)
+	b.WriteString("INSERT INTO " + s.hotTable + " (id, n) VALUES ")
+	for id := 1; id <= s.hotRows; id++ {
+		if id != 1 {
+			b.WriteString(", ")
+		}
+		b.WriteString("(?, 0)")
+		args = append(args, id)
+	}
+
+	return workload.Measure(ctx, "SEED", func() error {
+		_, err := s.exec(ctx, s.db, b.String(), args...)
+		return err
+	})
+}
+
+// seed inserts the configured number of rows in batches.
+func (s *sqlDB) seed(ctx context.Context) error {
+	for s.maxID < int64(s.records) {
+		n := s.records - int(s.maxID)
+		if n > seedBatch {
+			n = seedBatch
+		}
+
+		rows := s.newRows(n)
+		err := workload.Measure(ctx, "SEED", func() error {
+			return s.insertValues(ctx, s.db, rows)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqlDB) Step(ctx context.Context, i int) error {
+	if s.total == 0 {
+		// only connections are held, there's nothing to step
+		<-ctx.Done()
+		return nil
+	}
+
+	defer s.poolGauges(ctx)
+	n := rand.Intn(s.total)
+	for k, w := range s.weights {
+		if n < w {
+			err := s.run(ctx, sqlOps[k].name, i)
+			if c := sqlConflict(err); c != "" {
+				workload.SetGauge(ctx, c, atomic.AddInt64(s.conflicts[c], 1))
+				return nil
+			}
+			return err
+		}
+		n -= w
+	}
+	panic("unreachable")
+}
+
+// poolGauges reports the workload pool state.
+func (s *sqlDB) poolGauges(ctx context.Context) {
+	st := s.db.Stats()
+	workload.SetGauge(ctx, "open_connections", int64(st.OpenConnections))
+	workload.SetGauge(ctx, "in_use_connections", int64(st.InUse))
+	workload.SetGauge(ctx, "connection_waits", st.WaitCount)
+}
+
+// sqlConflict returns the gauge a transaction conflict error
+// is counted under or an empty string for other errors.
+func sqlConflict(err error) string {
+	switch e := err.(type) {
+	case *mysql.MySQLError:
+		switch e.Number {
+		case 1213: // ER_LOCK_DEADLOCK
+			return "deadlocks"
+		case 1205: // ER_LOCK_WAIT_TIMEOUT
+			return "lock_timeouts"
+		}
+	case *pq.Error:
+		switch e.Code {
+		case "40P01": // deadlock_detected
+			return "deadlocks"
+		case "40001": // serialization_failure
+			return "serialization_failures"
+		case "55P03": // lock_not_available
+			return "lock_timeouts"
+		}
+	}
+	return ""
+}
+
+func (s *sqlDB) run(ctx context.Context, op string, i int) error {
+	switch op {
+	case "select":
+		return workload.Measure(ctx, s.op("SELECT"), func() error {
+			var (
+				k       int
+				tag     string
+				payload string
+			)
+			err := s.queryRow(ctx, s.db, "SELECT k, tag, payload FROM "+s.table+" WHERE id = ?",
+				s.randomID()).Scan(&k, &tag, &payload)
+			if err == sql.ErrNoRows {
+				return nil
+			}
+			return err
+		})
+	case "range":
+		return workload.Measure(ctx, s.op("RANGE"), func() error {
+			k := rand.Intn(sqlKeys)
+			rows, err := s.query(ctx, s.db, "SELECT id, payload FROM "+s.table+" WHERE k BETWEEN ? AND ? ORDER BY k",
+				k, k+s.rangeSize-1)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+
+			var (
+				id      int64
+				payload string
+			)
+			for rows.Next() {
+				if err = rows.Scan(&id, &payload); err != nil {
+					return err
+				}
+			}
+			return rows.Err()
+		})
+	case "update":
+		return workload.Measure(ctx, s.op("UPDATE"), func() error {
+			_, err := s.exec(ctx, s.db, "UPDATE "+s.table+" SET k = ?, payload = ? WHERE id = ?",
+				rand.Intn(sqlKeys), s.randomPayload(), s.randomID())
+			return err
+		})
+	case "delete":
+		return workload.Measure(ctx, s.op("DELETE"), func() error {
+			_, err := s.exec(ctx, s.db, "DELETE FROM "+s.table+" WHERE id = ?", s.randomID())
+			return err
+		})
+	case "insert":
+		return workload.Measure(ctx, s.op("INSERT"), func() error {
+			_, err := s.exec(ctx, s.db, "INSERT INTO "+s.table+" (id, k, tag, payload) VALUES (?, ?, ?, ?)",
+				atomic.AddInt64(&s.maxID, 1), rand.Intn(sqlKeys), sqlTag(), s.randomPayload())
+			return err
+		})
+	case "txn":
+		return workload.Measure(ctx, s.op("TXN"), func() error {
+			return s.tx(ctx, func(tx *sql.Tx) error {
+				var payload string
+				id := s.randomID()
+				err := s.queryRow(ctx, tx, "SELECT payload FROM "+s.table+" WHERE id = ? FOR UPDATE", id).Scan(&payload)
+				if err != nil && err != sql.ErrNoRows {
+					return err
+				}
+				if _, err = s.exec(ctx, tx, "UPDATE "+s.table+" SET k = ? WHERE id = ?", rand.Intn(sqlKeys), id); err != nil {
+					return err
+				}
+				_, err = s.exec(ctx, tx, "INSERT INTO "+s.table+" (id, k, tag, payload) VALUES (?, ?, ?, ?)",
+					atomic.AddInt64(&s.maxID, 1), rand.Intn(sqlKeys), sqlTag(), s.randomPayload())
+				return err
+			})
+		})
+	case "hot":
+		return workload.Measure(ctx, s.op("HOT"), func() error {
+			return s.tx(ctx, func(tx *sql.Tx) error {
+				if err := s.bumpHot(ctx, tx, rand.Intn(s.hotRows)+1); err != nil {
+					return err
+				}
+				return sleep(ctx, s.hold)
+			})
+		})
+	case "deadlock":
+		// even and odd steps lock the same rows in the opposite order
+		a, b := 1, 2
+		if i%2 == 1 {
+			a, b = b, a
+		}
+		return workload.Measure(ctx, s.op("DEADLOCK"), func() error {
+			return s.tx(ctx, func(tx *sql.Tx) error {
+				if err := s.bumpHot(ctx, tx, a); err != nil {
+					return err
+				}
+				if err := sleep(ctx, s.hold); err != nil {
+					return err
+				}
+				return s.bumpHot(ctx, tx, b)
+			})
+		})
+	case "bulk":
+		return s.bulk(ctx)
+	case "churn":
+		return workload.Measure(ctx, "CONNECT", func() error {
+			conn, err := s.churnDB.Conn(ctx)
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			return conn.PingContext(ctx)
+		})
+	case "idle":
+		return workload.Measure(ctx, s.op("IDLE"), func() error {
+			return s.tx(ctx, func(tx *sql.Tx) error {
+				var n int64
+				err := s.queryRow(ctx, tx, "SELECT n FROM "+s.hotTable+" WHERE id = ?", rand.Intn(s.hotRows)+1).Scan(&n)
+				if err != nil {
+					return err
+				}
+				return sleep(ctx, s.idleTime)
+			})
+		})
+	}
+	return fmt.Errorf("unknown operation %q", op)
+}
+
+// tx runs fn in a transaction that's committed unless fn fails.
+func (s *sqlDB) tx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	var (
+		tx  *sql.Tx
+		err error
+	)
+	if s.isolation != "" {
+		// the isolation session variable name differs between MySQL
+		// versions and MariaDB, so the level is set for the next
+		// transaction of a connection taken out of the pool instead
+		var conn *sql.Conn
+		if conn, err = s.db.Conn(ctx); err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		if _, err = conn.ExecContext(ctx, "SET TRANSACTION ISOLATION LEVEL "+s.isolation); err != nil {
+			return err
+		}
+		tx, err = conn.BeginTx(ctx, nil)
+	} else {
+		tx, err = s.db.BeginTx(ctx, s.txOptions)
+	}
+	if err != nil {
+		return err
+	}
+	if err = fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlDB) bumpHot(ctx context.Context, tx *sql.Tx, id int) error {
+	_, err := s.exec(ctx, tx, "UPDATE "+s.hotTable+" SET n = n + 1 WHERE id = ?", id)
+	return err
+}
+
+// sleep pauses for d, it fails when ctx is done first.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *sqlDB) Teardown(ctx context.Context) error {
+	if s.db == nil {
+		return nil
+	}
+	defer s.db.Close()
+
+	// slow queries are waited for not to interfere with dropping tables
+	if s.slowStop != nil {
+		s.slowStop()
+	}
+	s.slowWG.Wait()
+	s.closeStmts()
+	for _, conn := range s.held {
+		conn.Close()
+	}
+	for _, db := range []*sql.DB{s.holdDB, s.churnDB} {
+		if db != nil {
+			db.Close()
+		}
+	}
+
+	return workload.Measure(ctx, "DROP", func() error {
+		for _, t := range []string{s.table, s.hotTable} {
+			if _, err := s.db.ExecContext(ctx, "DROP TABLE IF EXISTS "+t); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// rebind replaces ? placeholders with numbered ones when the driver needs it,
+// queries mustn't have question marks anywhere else.
+func (s *sqlDB) rebind(q string) string {
+	if !s.numbered {
+		return q
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, c := range q {
+		if c != '?' {
+			b.WriteRune(c)
+			continue
+		}
+		n++
+		b.WriteByte('$')
+		b.WriteString(strconv.Itoa(n))
+	}
+	return b.String()
+}
+
+// randomID returns an id of a row that's been inserted at some point
+// picked with the key distribution, so the first rows are the hot ones.
+func (s *sqlDB) randomID() int64 {
+	n := atomic.LoadInt64(&s.maxID)
+	if n == 0 {
+		return 0
+	}
+	return s.dist(n) + 1
+}
+
+func sqlTag() string {
+	return "tag-" + strconv.Itoa(rand.Intn(sqlTags))
+}
@@ -0,0 +1,162 @@
+package backends
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+
+	"cf-monitoring-demo-app/workload"
+)
+
+// sqlBulkModes are ways bulk operations write batches of rows.
+var sqlBulkModes = []string{
+	"values", // a single multi-row INSERT
+	"txn",    // single-row INSERTs wrapped in a transaction
+	"copy",   // COPY FROM STDIN on PostgreSQL and LOAD DATA LOCAL INFILE on MySQL
+}
+
+// sqlReaders makes LOAD DATA reader names unique, it's accessed atomically.
+var sqlReaders int64
+
+// sqlRow is a row of the main table.
+type sqlRow struct {
+	id      int64
+	k       int
+	tag     string
+	payload string
+}
+
+// size approximates the number of bytes the row takes on the wire.
+func (r *sqlRow) size() int {
+	return 8 + 4 + len(r.tag) + len(r.payload)
+}
+
+// newRows returns n random rows with new ids.
+func (s *sqlDB) newRows(n int) []sqlRow {
+	rows := make([]sqlRow, n)
+	for i := range rows {
+		rows[i] = sqlRow{
+			id:      atomic.AddInt64(&s.maxID, 1),
+			k:       rand.Intn(sqlKeys),
+			tag:     sqlTag(),
+			payload: s.randomPayload(),
+		}
+	}
+	return rows
+}
+
+// insertValues inserts rows with a single multi-row INSERT.
+func (s *sqlDB) insertValues(ctx context.Context, c sqlConn, rows []sqlRow) error {
+	var b strings.Builder
+	args := make([]interface{}, 0, len(rows)*4)
+	b.WriteString("INSERT INTO " + s.table + " (id, k, tag, payload) VALUES ")
+	for i, r := range rows {
+		if i != 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString("(?, ?, ?, ?)")
+		args = append(args, r.id, r.k, r.tag, r.payload)
+	}
+
+	_, err := s.exec(ctx, c, b.String(), args...)
+	return err
+}
+
+// bulk writes a batch of rows in the configured mode and
+// reports the total number of rows and bytes and their rates.
+func (s *sqlDB) bulk(ctx context.Context) error {
+	rows := s.newRows(s.batch)
+	err := workload.Measure(ctx, s.op("BULK"), func() error {
+		switch s.bulkMode {
+		case "txn":
+			return s.tx(ctx, func(tx *sql.Tx) error {
+				for i := range rows {
+					if err := s.insertValues(ctx, tx, rows[i:i+1]); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		case "copy":
+			if s.driver == "mysql" {
+				return s.loadData(ctx, rows)
+			}
+			return s.copyIn(ctx, rows)
+		default:
+			return s.insertValues(ctx, s.db, rows)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	size := 0
+	for i := range rows {
+		size += rows[i].size()
+	}
+	n := atomic.AddInt64(&s.bulkRows, int64(len(rows)))
+	b := atomic.AddInt64(&s.bulkBytes, int64(size))
+
+	workload.SetGauge(ctx, "bulk_rows", n)
+	workload.SetGauge(ctx, "bulk_bytes", b)
+	if sec := time.Since(s.started).Seconds(); sec > 0 {
+		workload.SetGauge(ctx, "bulk_rows_per_sec", int64(float64(n)/sec))
+		workload.SetGauge(ctx, "bulk_bytes_per_sec", int64(float64(b)/sec))
+	}
+	return nil
+}
+
+// copyIn writes rows with COPY FROM STDIN, that's only supported in transactions.
+func (s *sqlDB) copyIn(ctx context.Context, rows []sqlRow) error {
+	return s.tx(ctx, func(tx *sql.Tx) error {
+		stmt, err := tx.PrepareContext(ctx, pq.CopyIn(s.table, "id", "k", "tag", "payload"))
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		for _, r := range rows {
+			if _, err = stmt.ExecContext(ctx, r.id, r.k, r.tag, r.payload); err != nil {
+				return err
+			}
+		}
+		// an empty exec flushes buffered rows
+		_, err = stmt.ExecContext(ctx)
+		return err
+	})
+}
+
+// loadData writes rows with LOAD DATA LOCAL INFILE from a registered reader
+// of tab separated lines, payloads and tags don't need escaping,
+// the server must have local_infile enabled.
+func (s *sqlDB) loadData(ctx context.Context, rows []sqlRow) error {
+	var buf bytes.Buffer
+	for _, r := range rows {
+		buf.WriteString(strconv.FormatInt(r.id, 10))
+		buf.WriteByte('\t')
+		buf.WriteString(strconv.Itoa(r.k))
+		buf.WriteByte('\t')
+		buf.WriteString(r.tag)
+		buf.WriteByte('\t')
+		buf.WriteString(r.payload)
+		buf.WriteByte('\n')
+	}
+
+	name := "cf_monitoring-" + strconv.FormatInt(atomic.AddInt64(&sqlReaders, 1), 10)
+	mysql.RegisterReaderHandler(name, func() io.Reader {
+		return &buf
+	})
+	defer mysql.DeregisterReaderHandler(name)
+
+	_, err := s.db.ExecContext(ctx, "LOAD DATA LOCAL INFILE 'Reader::"+name+"' INTO TABLE "+s.table+" (id, k, tag, payload)")
+	return err
+}
@@ -0,0 +1,94 @@
+package backends
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+
+	"cf-monitoring-demo-app/workload"
+)
+
+// sqlSlowQueries are expensive queries issued in background, they're
+// picked by weights set with the options of the same names.
+var sqlSlowQueries = []struct {
+	name   string
+	op     string
+	weight int // default weight
+}{
+	{"slow_scan", "SLOW_SCAN", 1},
+	{"slow_sort", "SLOW_SORT", 1},
+	{"slow_join", "SLOW_JOIN", 1},
+	{"slow_sleep", "SLOW_SLEEP", 1},
+}
+
+// slowQueries issues expensive queries at the slow rate until ctx is done,
+// at most slowWorkers of them run at once, so the rate isn't kept when
+// they take longer than the workers can handle.
+func (s *sqlDB) slowQueries(ctx context.Context) {
+	defer s.slowWG.Done()
+
+	total := 0
+	for _, w := range s.slowWeights {
+		total += w
+	}
+
+	sem := make(chan struct{}, s.slowWorkers)
+	p := newPacer(s.slowRate)
+	for p.wait(ctx.Done()) {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+
+		n := rand.Intn(total)
+		k := 0
+		for ; n >= s.slowWeights[k]; k++ {
+			n -= s.slowWeights[k]
+		}
+
+		s.slowWG.Add(1)
+		go func() {
+			defer s.slowWG.Done()
+			defer func() {
+				<-sem
+			}()
+
+			workload.Measure(ctx, s.op(sqlSlowQueries[k].op), func() error {
+				return s.slowQuery(ctx, sqlSlowQueries[k].name)
+			})
+		}()
+	}
+}
+
+func (s *sqlDB) slowQuery(ctx context.Context, name string) error {
+	var n int64
+	switch name {
+	case "slow_scan":
+		// the payload isn't indexed
+		off := rand.Intn(len(payloads) - 3)
+		pattern := "%" + payloads[off:off+3] + "%"
+		return s.queryRow(ctx, s.db, "SELECT COUNT(*) FROM "+s.table+" WHERE payload LIKE ?", pattern).Scan(&n)
+	case "slow_sort":
+		// the offset makes the server sort at least a half of the table
+		err := s.queryRow(ctx, s.db, "SELECT id FROM "+s.table+" ORDER BY payload, k LIMIT 1 OFFSET ?",
+			atomic.LoadInt64(&s.maxID)/2).Scan(&n)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	case "slow_join":
+		return s.queryRow(ctx, s.db, fmt.Sprintf(`SELECT COUNT(*)
+			FROM (SELECT id FROM %[1]s LIMIT ?) a, (SELECT id FROM %[1]s LIMIT ?) b
+			WHERE a.id <> b.id`, s.table), s.joinRows, s.joinRows).Scan(&n)
+	case "slow_sleep":
+		q := "SELECT SLEEP(?)"
+		if s.driver != "mysql" {
+			q = "SELECT 1 FROM pg_sleep(?)"
+		}
+		return s.queryRow(ctx, s.db, q, s.sleepTime.Seconds()).Scan(&n)
+	}
+	panic("unknown slow query " + name)
+}
@@ -0,0 +1,169 @@
+package backends
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// sqlStatementModes are ways queries are sent to the server.
+var sqlStatementModes = []string{
+	"params",      // placeholders with arguments, the default
+	"prepared",    // statements prepared once and reused
+	"adhoc",       // arguments inlined into the query text
+	"interpolate", // MySQL interpolateParams, the driver inlines arguments
+}
+
+// sqlConn is either the pool or a transaction.
+type sqlConn interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// op returns the op name suffixed with the statement mode unless it's
+// the default one, so latencies of different modes are kept apart.
+func (s *sqlDB) op(name string) string {
+	if s.statements == "params" {
+		return name
+	}
+	return name + "/" + s.statements
+}
+
+func (s *sqlDB) exec(ctx context.Context, c sqlConn, q string, args ...interface{}) (sql.Result, error) {
+	switch s.statements {
+	case "prepared":
+		st, err := s.prepare(ctx, c, q)
+		if err != nil {
+			return nil, err
+		}
+		return st.ExecContext(ctx, args...)
+	case "adhoc":
+		return c.ExecContext(ctx, inline(q, args))
+	}
+	return c.ExecContext(ctx, s.rebind(q), args...)
+}
+
+func (s *sqlDB) query(ctx context.Context, c sqlConn, q string, args ...interface{}) (*sql.Rows, error) {
+	switch s.statements {
+	case "prepared":
+		st, err := s.prepare(ctx, c, q)
+		if err != nil {
+			return nil, err
+		}
+		return st.QueryContext(ctx, args...)
+	case "adhoc":
+		return c.QueryContext(ctx, inline(q, args))
+	}
+	return c.QueryContext(ctx, s.rebind(q), args...)
+}
+
+// queryRow is like query, but it defers preparation errors to Scan.
+func (s *sqlDB) queryRow(ctx context.Context, c sqlConn, q string, args ...interface{}) sqlScanner {
+	switch s.statements {
+	case "prepared":
+		st, err := s.prepare(ctx, c, q)
+		if err != nil {
+			return errScanner{err}
+		}
+		return st.QueryRowContext(ctx, args...)
+	case "adhoc":
+		return c.QueryRowContext(ctx, inline(q, args))
+	}
+	return c.QueryRowContext(ctx, s.rebind(q), args...)
+}
+
+type sqlScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+type errScanner struct {
+	err error
+}
+
+func (e errScanner) Scan(dest ...interface{}) error {
+	return e.err
+}
+
+// prepare returns the pool statement of q preparing it on the first use,
+// in transactions it's bound to the transaction connection.
+//
+// Statements aren't prepared on the pool in transactions, the transaction
+// may hold the last connection the pool is allowed to open, they're
+// prepared on the transaction until the pool one is there instead.
+func (s *sqlDB) prepare(ctx context.Context, c sqlConn, q string) (*sql.Stmt, error) {
+	s.stmtsMu.Lock()
+	st, ok := s.stmts[q]
+	s.stmtsMu.Unlock()
+
+	tx, inTx := c.(*sql.Tx)
+	if !ok {
+		if inTx {
+			return tx.PrepareContext(ctx, s.rebind(q))
+		}
+
+		// the lock isn't held while preparing, workers would wait
+		// for each other, so another worker may prepare it too
+		var err error
+		if st, err = s.db.PrepareContext(ctx, s.rebind(q)); err != nil {
+			return nil, err
+		}
+
+		s.stmtsMu.Lock()
+		if prev, ok := s.stmts[q]; ok {
+			st.Close()
+			st = prev
+		} else {
+			s.stmts[q] = st
+		}
+		s.stmtsMu.Unlock()
+	}
+
+	if inTx {
+		return tx.StmtContext(ctx, st), nil
+	}
+	return st, nil
+}
+
+// closeStmts closes all prepared statements.
+func (s *sqlDB) closeStmts() {
+	s.stmtsMu.Lock()
+	defer s.stmtsMu.Unlock()
+
+	for _, st := range s.stmts {
+		st.Close()
+	}
+	s.stmts = map[string]*sql.Stmt{}
+}
+
+// inline replaces ? placeholders with args as literals, strings are
+// only quoted, so they mustn't have backslashes for MySQL.
+func inline(q string, args []interface{}) string {
+	var b strings.Builder
+	n := 0
+	for _, c := range q {
+		if c != '?' {
+			b.WriteRune(c)
+			continue
+		}
+		b.WriteString(sqlLiteral(args[n]))
+		n++
+	}
+	return b.String()
+}
+
+func sqlLiteral(v interface{}) string {
+	switch v := v.(type) {
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case string:
+		return "'" + strings.Replace(v, "'", "''", -1) + "'"
+	}
+	panic(fmt.Sprintf("unsupported literal type %T", v))
+}
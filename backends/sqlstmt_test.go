@@ -0,0 +1,51 @@
+package backends
+
+import "testing"
+
+func TestRebind(t *testing.T) {
+	for _, c := range []struct {
+		numbered bool
+		q        string
+		want     string
+	}{
+		{false, "SELECT k FROM t WHERE id = ?", "SELECT k FROM t WHERE id = ?"},
+		{true, "SELECT k FROM t WHERE id = ?", "SELECT k FROM t WHERE id = $1"},
+		{true, "INSERT INTO t (a, b) VALUES (?, ?), (?, ?)", "INSERT INTO t (a, b) VALUES ($1, $2), ($3, $4)"},
+		{true, "SELECT 1", "SELECT 1"},
+	} {
+		s := &sqlDB{numbered: c.numbered}
+		if got := s.rebind(c.q); got != c.want {
+			t.Errorf("rebind(%q) = %q, want %q", c.q, got, c.want)
+		}
+	}
+}
+
+func TestInline(t *testing.T) {
+	for _, c := range []struct {
+		q    string
+		args []interface{}
+		want string
+	}{
+		{"SELECT 1", nil, "SELECT 1"},
+		{"SELECT k FROM t WHERE id = ?", []interface{}{int64(42)}, "SELECT k FROM t WHERE id = 42"},
+		{
+			"INSERT INTO t (a, b, c) VALUES (?, ?, ?)",
+			[]interface{}{7, "it's", .5},
+			"INSERT INTO t (a, b, c) VALUES (7, 'it''s', 0.5)",
+		},
+		{"SELECT SLEEP(?)", []interface{}{1.0}, "SELECT SLEEP(1)"},
+	} {
+		if got := inline(c.q, c.args); got != c.want {
+			t.Errorf("inline(%q, %v) = %q, want %q", c.q, c.args, got, c.want)
+		}
+	}
+}
+
+func TestSQLLiteralPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("unsupported literal type doesn't panic")
+		}
+	}()
+	sqlLiteral(true)
+}
@@ -0,0 +1,48 @@
+package backends
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"cf-monitoring-demo-app/vcap"
+)
+
+// serviceURL returns service credentials as an url, it's either the uri
+// credential or it's built from the hostname, port and other credentials.
+func serviceURL(svc *vcap.Service) (*url.URL, error) {
+	if s := svc.String("uri", "url"); s != "" {
+		return url.Parse(s)
+	}
+
+	host := svc.String("hostname", "host")
+	if host == "" {
+		return nil, fmt.Errorf("%s: neither uri nor hostname is in credentials", svc.Name)
+	}
+	if port := svc.String("port"); port != "" {
+		host = net.JoinHostPort(host, port)
+	}
+
+	u := &url.URL{Host: host}
+	if db := svc.String("name", "database", "db"); db != "" {
+		u.Path = "/" + db
+	}
+
+	user, pass := svc.String("username", "user"), svc.String("password")
+	if user != "" || pass != "" {
+		u.User = url.UserPassword(user, pass)
+	}
+	return u, nil
+}
+
+// schemelessURL returns u without the scheme, drivers add it themselves.
+func schemelessURL(svc *vcap.Service) (string, error) {
+	u, err := serviceURL(svc)
+	if err != nil {
+		return "", err
+	}
+
+	u.Scheme = ""
+	return strings.TrimPrefix(u.String(), "//"), nil
+}
@@ -0,0 +1,100 @@
+package backends
+
+import (
+	"testing"
+
+	"cf-monitoring-demo-app/vcap"
+)
+
+func TestServiceAddr(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		addr  func(svc *vcap.Service) (string, error)
+		creds map[string]interface{}
+		want  string // empty when an error is expected
+	}{
+		{
+			"uri", schemelessURL,
+			map[string]interface{}{"uri": "postgres://u:p@db:5432/app?sslmode=disable"},
+			"u:p@db:5432/app?sslmode=disable",
+		},
+		{
+			"hostname", schemelessURL,
+			map[string]interface{}{"hostname": "db", "port": float64(5432), "name": "app", "username": "u", "password": "p"},
+			"u:p@db:5432/app",
+		},
+		{
+			"host without port", schemelessURL,
+			map[string]interface{}{"host": "redis"},
+			"redis",
+		},
+		{
+			"no host", schemelessURL,
+			map[string]interface{}{"port": float64(6379)},
+			"",
+		},
+		{
+			"mysql uri", mysqlAddr,
+			map[string]interface{}{"uri": "mysql://u:p@db:3306/app?reconnect=true"},
+			"u:p@tcp(db:3306)/app",
+		},
+		{
+			"mysql hostname", mysqlAddr,
+			map[string]interface{}{"hostname": "db", "port": "3306", "name": "app", "username": "u", "password": "p"},
+			"u:p@tcp(db:3306)/app",
+		},
+		{
+			"memcache servers", memcacheAddr,
+			map[string]interface{}{"servers": "mc1:11211,mc2:11211", "host": "mc"},
+			"mc1:11211,mc2:11211",
+		},
+		{
+			"memcache hostname", memcacheAddr,
+			map[string]interface{}{"hostname": "mc", "port": float64(11211)},
+			"mc:11211",
+		},
+		{
+			"memcache no port", memcacheAddr,
+			map[string]interface{}{"hostname": "mc"},
+			"",
+		},
+		{
+			"cassandra keyspace", cassandraAddr,
+			map[string]interface{}{"node_ips": []interface{}{"10.0.0.1", "10.0.0.2"}, "keyspace_name": "ks"},
+			"10.0.0.1,10.0.0.2/ks",
+		},
+		{
+			"cassandra no keyspace", cassandraAddr,
+			map[string]interface{}{"contact_points": "c1"},
+			"c1",
+		},
+		{
+			"cassandra no hosts", cassandraAddr,
+			map[string]interface{}{"keyspace": "ks"},
+			"",
+		},
+		{
+			"rabbitmq protocols", rabbitMQAddr,
+			map[string]interface{}{
+				"uri":       "amqp://other",
+				"protocols": map[string]interface{}{"amqp": map[string]interface{}{"uri": "amqp://u:p@mq:5672/vhost"}},
+			},
+			"u:p@mq:5672/vhost",
+		},
+		{
+			"rabbitmq uri", rabbitMQAddr,
+			map[string]interface{}{"uri": "amqp://u:p@mq/vhost"},
+			"u:p@mq/vhost",
+		},
+	} {
+		got, err := c.addr(&vcap.Service{Name: c.name, Credentials: c.creds})
+		switch {
+		case c.want == "" && err == nil:
+			t.Errorf("%s: got %q, want an error", c.name, got)
+		case c.want != "" && err != nil:
+			t.Errorf("%s: %v", c.name, err)
+		case got != c.want:
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
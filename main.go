@@ -1,25 +1,20 @@
 package main
 
 import (
-	"database/sql"
-	"encoding/binary"
+	"context"
 	"fmt"
 	"html/template"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
-	"unsafe"
-
-	"github.com/bradfitz/gomemcache/memcache"
-	"github.com/garyburd/redigo/redis"
-	_ "github.com/go-sql-driver/mysql"
-	"github.com/gocql/gocql"
-	_ "github.com/lib/pq"
-	"github.com/streadway/amqp"
-	"gopkg.in/mgo.v2"
+
+	_ "cf-monitoring-demo-app/backends"
+	"cf-monitoring-demo-app/workload"
 )
 
 var indexTemplate = `
@@ -39,13 +34,73 @@ var indexTemplate = `
 		</nav>
 
 		<div class="container" >
-			<a class="btn btn-primary {{ if index . "mysql" }}disabled{{ end }}" href="/mysql">MySQL</a>
-			<a class="btn btn-success {{ if index . "pgsql" }}disabled{{ end }}" href="/pgsql">PostgreSQL</a>
-			<a class="btn btn-danger {{ if index . "redis" }}disabled{{ end }}" href="/redis">Redis</a>
-			<a class="btn btn-info {{ if index . "memcache" }}disabled{{ end }}" href="/memcache">Memcache</a>
-			<a class="btn btn-warning {{ if index . "mongodb" }}disabled{{ end }}" href="/mongodb">MongoDB</a>
-			<a class="btn btn-default {{ if index . "cassandra" }}disabled{{ end }}" href="/cassandra">Cassandra</a>
-			<a class="btn btn-default {{ if index . "rabbitmq" }}disabled{{ end }}" href="/rabbitmq">RabbitMQ</a>
+			<form class="form-inline" id="profile-form" method="get" style="margin-bottom: 20px">
+				<div class="form-group">
+					<label for="profile">Profile</label>
+					<input class="form-control" id="profile" name="profile" list="profiles" placeholder="flat">
+					<datalist id="profiles">
+						{{ range .Profiles }}<option value="{{ .Name }}">{{ if .Arg }}{{ .Name }}:&lt;{{ .Arg }}&gt;{{ end }}</option>{{ end }}
+					</datalist>
+				</div>
+				<div class="form-group">
+					<label for="workers">Workers</label>
+					<input class="form-control" id="workers" name="workers" type="number" min="1" placeholder="{{ .Workers }}">
+				</div>
+				<div class="form-group">
+					<label for="rate">Ops/sec</label>
+					<input class="form-control" id="rate" name="rate" type="number" min="0" step="any" placeholder="unlimited">
+				</div>
+				<div class="form-group">
+					<label for="options">Options</label>
+					<input class="form-control" id="options" name="options" list="distributions" placeholder="key=value,...">
+					<datalist id="distributions">
+						{{ range .Distributions }}<option value="distribution={{ .Name }}">{{ if .Arg }}distribution={{ .Name }}:&lt;{{ .Arg }}&gt;{{ end }}</option>{{ end }}
+					</datalist>
+				</div>
+			</form>
+
+			{{ range .Buttons }}
+			<button class="btn btn-{{ .Style }}" type="submit" form="profile-form" formaction="/{{ .ID }}"{{ with .Options }} title="Options:{{ range . }} {{ .Name }} - {{ .Usage }};{{ end }}"{{ end }} {{ if .Busy }}disabled{{ end }}>{{ .Label }}</button>
+			{{ if .Busy }}<a class="btn btn-link" href="/stop/{{ .ID }}">Stop</a>{{ end }}
+			{{ end }}
+			{{ if .Busy }}<a class="btn btn-danger pull-right" href="/stop">Stop all</a>{{ end }}
+			{{ if not .Buttons }}<p class="text-muted">No backends are configured.</p>{{ end }}
+
+			{{ if .Busy }}
+			<table class="table table-condensed" style="margin-top: 20px">
+				<thead>
+					<tr>
+						<th>Target</th>
+						<th>Operation</th>
+						<th>Count</th>
+						<th>Errors</th>
+						<th>Ops/sec</th>
+						<th>p50</th>
+						<th>p90</th>
+						<th>p99</th>
+						<th>Max</th>
+					</tr>
+				</thead>
+				<tbody>
+					{{ range .Buttons }}{{ $name := .ID }}{{ range .Stats }}
+					<tr>
+						<td>{{ $name }}</td>
+						<td>{{ .Op }}</td>
+						<td>{{ .Count }}</td>
+						<td>{{ .Errors }}</td>
+						<td>{{ printf "%.1f" .Rate }}</td>
+						<td>{{ .P50 }}</td>
+						<td>{{ .P90 }}</td>
+						<td>{{ .P99 }}</td>
+						<td>{{ .Max }}</td>
+					</tr>
+					{{ end }}{{ end }}
+				</tbody>
+			</table>
+			{{ range .Buttons }}{{ if .Gauges }}
+			<p class="text-muted">{{ .ID }}:{{ range .Gauges }} {{ .Name }}={{ .Value }}{{ end }}</p>
+			{{ end }}{{ end }}
+			{{ end }}
 		</div>
 
 		<script src="//code.jquery.com/jquery-3.1.1.min.js" integrity="sha256-hVVnYaiADRTO2PzUGmuLJr8BLUSjGIZsDYGmIJLv2b8=" crossorigin="anonymous"></script>
@@ -55,9 +110,26 @@ var indexTemplate = `
 `
 
 var mu = sync.Mutex{}
-var ss = map[string]bool{}
 
-type timerFunc func() bool
+// maxWorkers limits the number of workers of a single run.
+const maxWorkers = 256
+
+var (
+	// loadSec is the default run duration in seconds.
+	loadSec = envInt("LOAD_SEC", 900)
+
+	// loadWorkers is the default number of workers per run,
+	// it's clamped to the range runs are allowed to have.
+	loadWorkers = clamp(envInt("LOAD_WORKERS", 1), 1, maxWorkers)
+
+	// teardownSec limits how long a run teardown may take,
+	// it is extended for draining queues at a limited rate.
+	teardownSec = envInt("TEARDOWN_TIMEOUT", 30)
+
+	// shutdownSec is how long the app waits for jobs
+	// to tear down and requests to finish on shutdown.
+	shutdownSec = envInt("SHUTDOWN_TIMEOUT", 8)
+)
 
 func main() {
 	tpl, err := template.New("index").Parse(indexTemplate)
@@ -71,262 +143,230 @@ func main() {
 			return
 		}
 
-		w.WriteHeader(http.StatusOK)
-		if err := tpl.ExecuteTemplate(w, "index", ss); err != nil {
-			panic(err)
+		type button struct {
+			*target
+			Busy   bool
+			Stats  []workload.OpStats
+			Gauges []workload.Gauge
 		}
-	})
 
-	sec := envInt("LOAD_SEC", 900)
-
-	for path, s := range map[string]*struct {
-		fn   func(string, timerFunc) error
-		addr string
-	}{
-		"mysql":     {testMySQL, envStringMust("MYSQL_URL")},
-		"pgsql":     {testPGSQL, envStringMust("PGSQL_URL")},
-		"redis":     {testRedis, envStringMust("REDIS_URL")},
-		"memcache":  {testMemcache, envStringMust("MEMCACHE_ADDR")},
-		"mongodb":   {testMongoDB, envStringMust("MONGODB_URL")},
-		"cassandra": {testCassandra, envStringMust("CASSANDRA_URL")},
-		"rabbitmq":  {testRabbitMQ, envStringMust("RABBITMQ_URL")},
-	} {
-		s := s
-		path := path
-
-		http.HandleFunc("/"+path, func(w http.ResponseWriter, r *http.Request) {
-			mu.Lock()
-			if ss[path] {
-				http.Error(w, fmt.Sprintf("%s is busy now", path), http.StatusInternalServerError)
-				mu.Unlock()
-				return
+		var data struct {
+			Buttons       []button
+			Busy          bool
+			Profiles      []workload.SpecInfo
+			Distributions []workload.SpecInfo
+			Workers       int
+		}
+		data.Profiles = workload.Profiles
+		data.Distributions = workload.Distributions
+		data.Workers = loadWorkers
+
+		mu.Lock()
+		for _, t := range enabled {
+			b := button{target: t}
+			if j, ok := ss[t.ID()]; ok {
+				b.Busy = true
+				b.Stats = j.stats.Snapshot()
+				b.Gauges = j.stats.Gauges()
 			}
-			ss[path] = true
-			mu.Unlock()
-
-			go func() {
-				defer func() {
-					mu.Lock()
-					delete(ss, path)
-					mu.Unlock()
-				}()
-
-				if err := s.fn(s.addr, makeTimer(sec)); err != nil {
-					fmt.Fprintf(os.Stderr, "%s error: %v\n", path, err)
-				}
-				fmt.Printf("%s %dsec done\n", path, sec)
-			}()
-
-			http.Redirect(w, r, "/", http.StatusFound)
-		})
-	}
+			data.Buttons = append(data.Buttons, b)
+		}
+		data.Busy = len(ss) != 0
+		mu.Unlock()
 
-	// cf compatibility
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
+		w.WriteHeader(http.StatusOK)
+		if err := tpl.ExecuteTemplate(w, "index", data); err != nil {
+			panic(err)
+		}
+	})
 
-	panic(http.ListenAndServe(":"+port, nil))
-}
+	http.HandleFunc("/stop", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		for _, j := range ss {
+			j.cancel()
+		}
+		mu.Unlock()
 
-func envStringMust(k string) string {
-	v := os.Getenv(k)
-	if v == "" {
-		fmt.Fprintf(os.Stderr, "$%s is required\n", k)
-		os.Exit(1)
-	}
-	return v
-}
+		http.Redirect(w, r, "/", http.StatusFound)
+	})
 
-func envInt(k string, d int) int {
-	s := os.Getenv(k)
-	if s == "" {
-		return d
-	}
+	http.HandleFunc("/stop/", func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/stop/")
 
-	i, err := strconv.Atoi(s)
-	if err != nil {
-		return d
-	}
+		mu.Lock()
+		j, ok := ss[path]
+		mu.Unlock()
 
-	return i
-}
+		if !ok {
+			http.Error(w, fmt.Sprintf("%s is not running", path), http.StatusNotFound)
+			return
+		}
 
-func makeTimer(sec int) timerFunc {
-	var stop time.Time
+		j.cancel()
+		http.Redirect(w, r, "/", http.StatusFound)
+	})
 
-	return func() bool {
-		if stop.IsZero() {
-			stop = time.Now().Add(time.Second * time.Duration(sec))
+	http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if err := workload.WriteMetrics(w); err != nil {
+			return
 		}
 
-		return time.Now().Before(stop)
-	}
-}
+		fmt.Fprintf(w, "# HELP %sactive_jobs Number of running load jobs.\n", workload.MetricsPrefix)
+		fmt.Fprintf(w, "# TYPE %sactive_jobs gauge\n", workload.MetricsPrefix)
 
-func testMySQL(url string, fn timerFunc) error {
-	return testSQLDB("mysql", url, fn)
-}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, t := range enabled {
+			n := 0
+			if _, ok := ss[t.ID()]; ok {
+				n = 1
+			}
+			fmt.Fprintf(w, "%sactive_jobs{backend=%s,target=%s} %d\n", workload.MetricsPrefix,
+				workload.QuoteLabel(t.Name), workload.QuoteLabel(t.ID()), n)
+		}
+	})
 
-func testPGSQL(url string, fn timerFunc) error {
-	return testSQLDB("postgres", "postgres://"+url, fn)
-}
+	http.HandleFunc(apiRunsPath, handleAPIRuns)
+	http.HandleFunc(apiRunsPath+"/", handleAPIRuns)
 
-func testSQLDB(driver, url string, fn timerFunc) error {
-	db, err := sql.Open(driver, url)
-	if err != nil {
-		return err
-	}
+	enable()
+	for _, t := range enabled {
+		t := t
 
-	_, err = db.Exec("CREATE TABLE IF NOT EXISTS cf_monitoring (i INT)")
-	if err != nil {
-		return err
-	}
+		http.HandleFunc("/"+t.ID(), func(w http.ResponseWriter, r *http.Request) {
+			p := defaultParams(t)
+			if n, err := strconv.Atoi(r.URL.Query().Get("workers")); err == nil && n > 0 && n <= maxWorkers {
+				p.Concurrency = n
+			}
+			if f, err := strconv.ParseFloat(r.URL.Query().Get("rate"), 64); err == nil && f > 0 {
+				p.Rate = f
+			}
+			if s := r.URL.Query().Get("profile"); s != "" {
+				p.Profile = s
+			}
+			o, err := workload.ParseOptions(r.URL.Query().Get("options"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			p.Options = p.Options.Merge(o)
 
-	for i := 0; fn(); i++ {
-		if _, err = db.Exec("INSERT INTO cf_monitoring VALUES (1)"); err != nil {
-			return err
-		}
-	}
+			_, err = startJob(t, p)
+			switch {
+			case err == errBusy:
+				http.Error(w, fmt.Sprintf("%s is busy now", t.ID()), http.StatusInternalServerError)
+				return
+			case err == errShutdown:
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			case err != nil:
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
 
-	if _, err = db.Exec("DROP TABLE cf_monitoring"); err != nil {
-		return err
+			http.Redirect(w, r, "/", http.StatusFound)
+		})
 	}
 
-	return nil
-}
-
-func testRedis(url string, fn timerFunc) error {
-	conn, err := redis.DialURL("redis://" + url)
-	if err != nil {
-		return err
+	// cf compatibility
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
 	}
-	defer conn.Close()
-
-	for i := 0; fn(); i++ {
-		key := "cf_monitoring-" + strconv.Itoa(i)
 
-		if _, err := conn.Do("SET", key, i); err != nil {
-			return err
-		}
-		if _, err := conn.Do("DEL", key); err != nil {
-			return err
+	srv := &http.Server{Addr: ":" + port}
+	go func() {
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			panic(err)
 		}
-	}
-
-	return nil
-}
-
-func testMemcache(addr string, fn timerFunc) error {
-	mc := memcache.New(addr)
+	}()
 
-	b := make([]byte, unsafe.Sizeof(uint64(0)))
-	for i := 0; fn(); i++ {
-		binary.LittleEndian.PutUint64(b, uint64(i))
+	// cf sends SIGTERM and kills the app 10 seconds later
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
+	fmt.Printf("%s received, shutting down\n", <-sig)
 
-		if err := mc.Set(&memcache.Item{
-			Key:   "cf_monitoring-" + strconv.Itoa(i),
-			Value: b,
-		}); err != nil {
-			return err
-		}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(shutdownSec))
+	defer cancel()
 
-		if err := mc.Delete("cf_monitoring-" + strconv.Itoa(i)); err != nil {
-			return err
-		}
+	if err := stopJobs(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "jobs are not torn down: %v\n", err)
+	}
+	if err := srv.Shutdown(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "http server shutdown error: %v\n", err)
 	}
-
-	return nil
 }
 
-func testMongoDB(url string, fn timerFunc) error {
-	mg, err := mgo.Dial("mongodb://" + url)
-	if err != nil {
-		return err
+// defaultParams returns t run parameters configured with environment,
+// the target rate can be set for all targets with $LOAD_RATE and
+// overridden per backend and per target, e.g. with $LOAD_RATE_MYSQL
+// and $LOAD_RATE_MYSQL_ORDERS, driver options are set the same way
+// with $LOAD_OPTIONS_RABBITMQ and $LOAD_OPTIONS_RABBITMQ_EVENTS.
+func defaultParams(t *target) runParams {
+	rate := envFloat("LOAD_RATE", 0)
+	rate = envFloat("LOAD_RATE_"+strings.ToUpper(t.Name), rate)
+	rate = envFloat("LOAD_RATE_"+t.envSuffix(), rate)
+
+	opts := envOptions("LOAD_OPTIONS_"+strings.ToUpper(t.Name), nil)
+	if t.Instance != "" {
+		opts = envOptions("LOAD_OPTIONS_"+t.envSuffix(), opts)
 	}
-	defer mg.Close()
-
-	c := mg.DB("").C("demo")
 
-	for i := 0; fn(); i++ {
-		if err = c.Insert(struct {
-			I int
-		}{i}); err != nil {
-			return err
-		}
+	return runParams{
+		Duration:    time.Second * time.Duration(loadSec),
+		Concurrency: loadWorkers,
+		Rate:        rate,
+		Options:     opts,
 	}
-
-	return c.DropCollection()
 }
 
-func testCassandra(url string, fn timerFunc) error {
-	chunks := strings.SplitN(url, "/", 2)
-	hosts := strings.Split(chunks[0], ",")
-
-	cfg := gocql.NewCluster(hosts...)
-	if len(chunks) == 2 {
-		cfg.Keyspace = chunks[1]
+func envInt(k string, d int) int {
+	s := os.Getenv(k)
+	if s == "" {
+		return d
 	}
 
-	sess, err := cfg.CreateSession()
+	i, err := strconv.Atoi(s)
 	if err != nil {
-		return err
-	}
-	defer sess.Close()
-
-	if err = sess.Query("CREATE TABLE IF NOT EXISTS cf_monitoring (id int PRIMARY KEY)").Exec(); err != nil {
-		return err
+		return d
 	}
 
-	for i := 0; fn(); i++ {
-		if err = sess.Query("INSERT INTO cf_monitoring (id) VALUES (?)", i).Exec(); err != nil {
-			return err
-		}
-	}
+	return i
+}
 
-	if err = sess.Query("DROP TABLE cf_monitoring").Exec(); err != nil {
-		return err
+// clamp limits i to [min, max].
+func clamp(i, min, max int) int {
+	switch {
+	case i < min:
+		return min
+	case i > max:
+		return max
 	}
-
-	return nil
+	return i
 }
 
-func testRabbitMQ(addr string, fn timerFunc) error {
-	conn, err := amqp.Dial("amqp://" + addr)
-	if err != nil {
-		return err
+func envFloat(k string, d float64) float64 {
+	s := os.Getenv(k)
+	if s == "" {
+		return d
 	}
 
-	ch, err := conn.Channel()
+	f, err := strconv.ParseFloat(s, 64)
 	if err != nil {
-		return err
+		return d
 	}
 
-	if err = ch.ExchangeDeclare("cf_monitoring", "direct", false, true, false, false, nil); err != nil {
-		return err
-	}
+	return f
+}
 
-	q, err := ch.QueueDeclare("cf_monitoring-q", false, false, true, false, nil)
+// envOptions returns d overridden with options set in k,
+// malformed options are reported and ignored.
+func envOptions(k string, d workload.Options) workload.Options {
+	o, err := workload.ParseOptions(os.Getenv(k))
 	if err != nil {
-		return err
-	}
-
-	for i := 0; fn(); i++ {
-		err = ch.Publish("cf_monitoring", "", false, false, amqp.Publishing{
-			ContentType: "text/plain",
-			Body:        []byte(strconv.Itoa(i)),
-		})
-
-		if err != nil {
-			return err
-		}
-
-		_, err = ch.Consume(q.Name, "", true, false, false, false, nil)
-		if err != nil {
-			return err
-		}
+		fmt.Fprintf(os.Stderr, "$%s: %v\n", k, err)
+		return d
 	}
-
-	return nil
+	return d.Merge(o)
 }
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"cf-monitoring-demo-app/workload"
+)
+
+// maxRuns is the number of runs kept in the history.
+const maxRuns = 100
+
+const (
+	statusRunning = "running"
+	statusDone    = "done"
+	statusStopped = "stopped"
+	statusFailed  = "failed"
+)
+
+var (
+	errBusy     = errors.New("target is busy")
+	errShutdown = errors.New("application is shutting down")
+)
+
+var (
+	// ss holds running jobs keyed by target id.
+	ss = map[string]*job{}
+
+	// runs is the history of runs, the newest is the last.
+	runs   []*job
+	lastID int
+
+	// wg tracks running jobs until they're torn down.
+	wg sync.WaitGroup
+
+	// shutdown is set once the application starts shutting down.
+	shutdown bool
+)
+
+// namespacePrefix is the prefix of run namespaces, run ids start over
+// in every process, so it has the instance index and a random part
+// to keep namespaces unique across instances, applications and restarts.
+var namespacePrefix = func() string {
+	index, _ := strconv.Atoi(os.Getenv("CF_INSTANCE_INDEX"))
+
+	// math/rand isn't seeded randomly by older Go versions
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		binary.BigEndian.PutUint32(b, uint32(time.Now().UnixNano()))
+	}
+	return fmt.Sprintf("cf_monitoring_%d_%x", index, b)
+}()
+
+type job struct {
+	id        string
+	namespace string
+	target    *target
+	params    runParams
+	status    string
+	err       error
+	tderr     error // teardown error
+	started   time.Time
+	finished  time.Time
+	cancel    context.CancelFunc
+	stats     *workload.Stats
+}
+
+// runParams are user adjustable parameters of a run.
+type runParams struct {
+	Duration    time.Duration
+	Concurrency int     // number of workers
+	Rate        float64 // target steps per second, zero is unlimited
+	Profile     string  // load profile spec, see workload.ParseProfile
+	Options     workload.Options
+}
+
+// startJob starts a t run in background, it fails with errBusy
+// when there's another run of the same target in progress
+// and with errShutdown when the application is stopping.
+func startJob(t *target, p runParams) (*job, error) {
+	profile, err := workload.ParseProfile(p.Profile)
+	if err != nil {
+		return nil, err
+	}
+	if err = p.Options.Check(t.Options); err != nil {
+		return nil, err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if shutdown {
+		return nil, errShutdown
+	}
+	if _, ok := ss[t.ID()]; ok {
+		return nil, errBusy
+	}
+
+	// the run duration is counted by workload.Run once the target is set up
+	ctx, cancel := context.WithCancel(context.Background())
+	lastID++
+	id := strconv.Itoa(lastID)
+	j := &job{
+		id:        id,
+		namespace: namespacePrefix + "_" + id,
+		target:    t,
+		params:    p,
+		status:    statusRunning,
+		started:   time.Now(),
+		cancel:    cancel,
+		stats:     workload.NewStats(t.Name, t.ID()),
+	}
+
+	ss[t.ID()] = j
+	runs = append(runs, j)
+	if len(runs) > maxRuns {
+		runs = runs[len(runs)-maxRuns:]
+	}
+
+	wg.Add(1)
+	go j.run(ctx, workload.Config{
+		Addr:      t.Addr,
+		Namespace: j.namespace,
+		Workers:   p.Concurrency,
+		Rate:      p.Rate,
+		Duration:  p.Duration,
+		Profile:   profile,
+		Options:   p.Options,
+
+		TeardownTimeout: time.Second * time.Duration(teardownSec),
+	})
+	return j, nil
+}
+
+func (j *job) run(ctx context.Context, cfg workload.Config) {
+	defer wg.Done()
+
+	err, tderr := workload.Run(ctx, j.target.New(), cfg, j.stats)
+	// a run interrupted before it's over, even in the middle of the setup,
+	// is stopped rather than failed
+	stopped := ctx.Err() != nil
+	if stopped {
+		err = nil
+	}
+	j.cancel()
+
+	mu.Lock()
+	delete(ss, j.target.ID())
+	j.finished = time.Now()
+	j.tderr = tderr
+	switch {
+	case err != nil:
+		j.status, j.err = statusFailed, err
+	case stopped:
+		j.status = statusStopped
+	default:
+		j.status = statusDone
+	}
+	mu.Unlock()
+
+	id := j.target.ID()
+	switch {
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "%s error: %v\n", id, err)
+	case stopped:
+		fmt.Printf("%s stopped\n", id)
+	default:
+		fmt.Printf("%s %s done\n", id, j.params.Duration)
+	}
+	if tderr != nil {
+		fmt.Fprintf(os.Stderr, "%s teardown error: %v\n", id, tderr)
+	}
+
+	for _, o := range j.stats.Snapshot() {
+		fmt.Printf("%s %s: %d ops, %d errors, %.1f ops/sec, p50=%s p90=%s p99=%s max=%s\n",
+			id, o.Op, o.Count, o.Errors, o.Rate, o.P50, o.P90, o.P99, o.Max)
+	}
+	if l := j.stats.Lag(); l.Count != 0 {
+		fmt.Printf("%s schedule lag: p50=%s p90=%s p99=%s max=%s\n",
+			id, l.P50, l.P90, l.P99, l.Max)
+	}
+	for _, g := range j.stats.Gauges() {
+		fmt.Printf("%s %s: %d\n", id, g.Name, g.Value)
+	}
+}
+
+// stopJobs prevents new runs from being started, stops running ones and
+// waits until they're torn down, it gives up when ctx is done.
+func stopJobs(ctx context.Context) error {
+	mu.Lock()
+	shutdown = true
+	for _, j := range ss {
+		j.cancel()
+	}
+	mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// findJob returns the run by its id or nil when it's not in the history.
+func findJob(id string) *job {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, j := range runs {
+		if j.id == id {
+			return j
+		}
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"cf-monitoring-demo-app/vcap"
+	"cf-monitoring-demo-app/workload"
+)
+
+// target is a configured backend instance a load can be run against,
+// a driver can have several of them distinguished by instance names.
+type target struct {
+	*workload.Driver
+
+	// Instance is the instance name, it's empty for the default instance.
+	Instance string
+
+	// Addr is the backend address passed to the driver.
+	Addr string
+
+	// source describes where the target is configured from.
+	source string
+}
+
+// enabled are configured targets ordered by driver registration
+// order and then by instance name.
+var enabled []*target
+
+// ID returns the target name used as its route path and busy state key,
+// it's the driver name optionally followed by the instance name,
+// e.g. "mysql" or "mysql/orders".
+func (t *target) ID() string {
+	if t.Instance == "" {
+		return t.Name
+	}
+	return t.Name + "/" + t.Instance
+}
+
+// Label returns the index page button label.
+func (t *target) Label() string {
+	if t.Instance == "" {
+		return t.Title
+	}
+	return t.Title + " (" + t.Instance + ")"
+}
+
+// envSuffix converts the target id to an environment variable suffix,
+// e.g. "mysql/orders" to "MYSQL_ORDERS".
+func (t *target) envSuffix() string {
+	return strings.ToUpper(strings.NewReplacer("/", "_", "-", "_", ".", "_").Replace(t.ID()))
+}
+
+// enable configures targets of all registered drivers either from the
+// environment or from bound services, environment variables take precedence.
+//
+// The default instance is read from the driver variable, e.g. $MYSQL_URL,
+// named instances from variables with the instance name suffix, e.g.
+// $MYSQL_URL_ORDERS is the "mysql/orders" target. When there are no
+// variables set every suitable bound service becomes a target, it's
+// the default one if it's the only one or it's named after the service.
+func enable() {
+	services, err := vcap.Parse(os.Getenv("VCAP_SERVICES"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+
+	for _, d := range workload.Drivers() {
+		ts := envTargets(d)
+		if len(ts) == 0 {
+			ts = serviceTargets(d, services)
+		}
+		if len(ts) == 0 {
+			fmt.Printf("%s is disabled, neither $%s is set nor a service is bound\n", d.Name, d.Env)
+			continue
+		}
+
+		sort.Slice(ts, func(i, j int) bool {
+			if ts[i].Instance != ts[j].Instance {
+				return ts[i].Instance < ts[j].Instance
+			}
+			return ts[i].source < ts[j].source
+		})
+		for k, t := range ts {
+			// different names may map to the same instance name
+			if k > 0 && ts[k-1].Instance == t.Instance {
+				fmt.Fprintf(os.Stderr, "%s: %s is skipped, the target is already enabled with %s\n",
+					t.ID(), t.source, ts[k-1].source)
+				// further duplicates are reported against the enabled one
+				ts[k] = ts[k-1]
+				continue
+			}
+			enabled = append(enabled, t)
+			fmt.Printf("%s is enabled with %s\n", t.ID(), t.source)
+		}
+	}
+}
+
+func envTargets(d *workload.Driver) []*target {
+	var ts []*target
+	for _, kv := range os.Environ() {
+		i := strings.IndexByte(kv, '=')
+		k, v := kv[:i], kv[i+1:]
+		if v == "" {
+			continue
+		}
+
+		var inst string
+		switch {
+		case k == d.Env:
+		case strings.HasPrefix(k, d.Env+"_"):
+			if inst = instanceName(k[len(d.Env)+1:]); inst == "" {
+				fmt.Fprintf(os.Stderr, "%s: $%s is skipped, the instance name is empty\n", d.Name, k)
+				continue
+			}
+		default:
+			continue
+		}
+
+		ts = append(ts, &target{Driver: d, Instance: inst, Addr: v, source: "$" + k})
+	}
+	return ts
+}
+
+func serviceTargets(d *workload.Driver, services []*vcap.Service) []*target {
+	if d.ServiceAddr == nil {
+		return nil
+	}
+
+	var found []*vcap.Service
+	for _, svc := range services {
+		if svc.Match(d.Services) {
+			found = append(found, svc)
+		}
+	}
+
+	var ts []*target
+	for _, svc := range found {
+		addr, err := d.ServiceAddr(svc)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %q service is skipped: %v\n", d.Name, svc.Name, err)
+			continue
+		}
+
+		t := &target{Driver: d, Addr: addr, source: fmt.Sprintf("%q service", svc.Name)}
+		if len(found) > 1 {
+			if t.Instance = instanceName(svc.Name); t.Instance == "" {
+				fmt.Fprintf(os.Stderr, "%s: %s is skipped, the instance name is empty\n", d.Name, t.source)
+				continue
+			}
+		}
+		ts = append(ts, t)
+	}
+	return ts
+}
+
+// instanceName lowercases s and replaces characters
+// that aren't welcome in url paths with dashes.
+func instanceName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		default:
+			return '-'
+		}
+	}, s)
+}
+
+// lookupTarget returns the target by its id or nil when it's not configured.
+func lookupTarget(id string) *target {
+	for _, t := range enabled {
+		if t.ID() == id {
+			return t
+		}
+	}
+	return nil
+}
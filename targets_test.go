@@ -0,0 +1,83 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInstanceName(t *testing.T) {
+	for _, c := range []struct {
+		s    string
+		want string
+	}{
+		{"orders", "orders"},
+		{"ORDERS_1", "orders_1"},
+		{"Orders-DB", "orders-db"},
+		{"my orders.v2", "my-orders-v2"},
+		{"", ""},
+	} {
+		if got := instanceName(c.s); got != c.want {
+			t.Errorf("instanceName(%q) = %q, want %q", c.s, got, c.want)
+		}
+	}
+}
+
+// enableDriver enables targets and returns addresses
+// of the enabled targets of the named driver by ids.
+func enableDriver(t *testing.T, name string) map[string]string {
+	defer func(ts []*target) { enabled = ts }(enabled)
+	enabled = nil
+	enable()
+
+	addrs := map[string]string{}
+	for _, tg := range enabled {
+		if tg.Name == name {
+			addrs[tg.ID()] = tg.Addr
+		}
+	}
+	return addrs
+}
+
+func TestEnableEnv(t *testing.T) {
+	t.Setenv("VCAP_SERVICES", `{"memcached": [{"name": "cache", "credentials": {"servers": "cache:11211"}}]}`)
+	t.Setenv("MEMCACHE_ADDR", "default:11211")
+	t.Setenv("MEMCACHE_ADDR_ORDERS", "a:11211")
+	t.Setenv("MEMCACHE_ADDR_orders", "b:11211")
+	t.Setenv("MEMCACHE_ADDR_", "empty:11211")
+
+	// the environment takes precedence over services and of the variables
+	// mapped to the same instance the first one in the sorted order wins
+	want := map[string]string{
+		"memcache":        "default:11211",
+		"memcache/orders": "a:11211",
+	}
+	if got := enableDriver(t, "memcache"); !reflect.DeepEqual(got, want) {
+		t.Errorf("enabled %v, want %v", got, want)
+	}
+}
+
+func TestEnableServices(t *testing.T) {
+	for _, c := range []struct {
+		services string
+		want     map[string]string
+	}{
+		{
+			`{"memcached": [{"name": "cache", "credentials": {"servers": "cache:11211"}}]}`,
+			map[string]string{"memcache": "cache:11211"},
+		},
+		{
+			`{"memcached": [
+				{"name": "orders", "credentials": {"servers": "a:11211"}},
+				{"name": "Orders", "credentials": {"servers": "b:11211"}},
+				{"name": "sessions", "credentials": {"servers": "c:11211"}},
+				{"name": "broken", "credentials": {}}
+			]}`,
+			map[string]string{"memcache/orders": "b:11211", "memcache/sessions": "c:11211"},
+		},
+	} {
+		t.Setenv("VCAP_SERVICES", c.services)
+		if got := enableDriver(t, "memcache"); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("enabled %v, want %v", got, c.want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+// Package vcap parses cloud foundry service bindings.
+package vcap
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Service is a bound service instance.
+type Service struct {
+	Name        string                 `json:"name"`
+	Label       string                 `json:"label"`
+	Tags        []string               `json:"tags"`
+	Credentials map[string]interface{} `json:"credentials"`
+}
+
+// Parse parses the $VCAP_SERVICES content, an empty string is no services.
+// Services are sorted by name to make the result stable.
+func Parse(s string) ([]*Service, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var m map[string][]*Service
+	if err := json.Unmarshal([]byte(s), &m); err != nil {
+		return nil, fmt.Errorf("vcap: malformed VCAP_SERVICES: %v", err)
+	}
+
+	var res []*Service
+	for label, list := range m {
+		for _, svc := range list {
+			if svc.Label == "" {
+				svc.Label = label
+			}
+			res = append(res, svc)
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res, nil
+}
+
+// Match reports whether the service label or any of its tags is in names.
+func (s *Service) Match(names []string) bool {
+	for _, n := range names {
+		if s.Label == n {
+			return true
+		}
+		for _, t := range s.Tags {
+			if t == n {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// String returns the first non-empty credential value of the given keys,
+// numbers are formatted as integers since that's what ports usually are.
+func (s *Service) String(keys ...string) string {
+	return lookup(s.Credentials, keys...)
+}
+
+func lookup(m map[string]interface{}, keys ...string) string {
+	for _, k := range keys {
+		switch v := m[k].(type) {
+		case string:
+			if v != "" {
+				return v
+			}
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		case []interface{}:
+			// e.g. cassandra node_ips
+			var s string
+			for i, e := range v {
+				if i != 0 {
+					s += ","
+				}
+				s += fmt.Sprint(e)
+			}
+			if s != "" {
+				return s
+			}
+		}
+	}
+	return ""
+}
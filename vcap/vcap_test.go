@@ -0,0 +1,71 @@
+package vcap
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	services, err := Parse(`{
+		"p-mysql": [{"name": "orders", "tags": ["mysql"], "credentials": {"port": 3306}}],
+		"user-provided": [{"name": "cache", "label": "memcache", "credentials": {}}]
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(services) != 2 {
+		t.Fatalf("got %d services, want 2", len(services))
+	}
+
+	// services are sorted by name and labels default to the section names
+	for i, want := range []struct{ name, label string }{
+		{"cache", "memcache"},
+		{"orders", "p-mysql"},
+	} {
+		if s := services[i]; s.Name != want.name || s.Label != want.label {
+			t.Errorf("service %d is %q labeled %q, want %q labeled %q", i, s.Name, s.Label, want.name, want.label)
+		}
+	}
+
+	if !services[1].Match([]string{"postgres", "mysql"}) {
+		t.Error("orders doesn't match by its tag")
+	}
+	if !services[0].Match([]string{"memcache"}) {
+		t.Error("cache doesn't match by its label")
+	}
+	if services[0].Match([]string{"redis"}) {
+		t.Error("cache matches a foreign name")
+	}
+}
+
+func TestParseEmpty(t *testing.T) {
+	if services, err := Parse(""); services != nil || err != nil {
+		t.Errorf("Parse(\"\") = %v, %v, want no services", services, err)
+	}
+	if _, err := Parse("{"); err == nil {
+		t.Error("malformed services are parsed")
+	}
+}
+
+func TestString(t *testing.T) {
+	svc := &Service{Credentials: map[string]interface{}{
+		"empty":    "",
+		"host":     "db.example.com",
+		"port":     float64(5432),
+		"node_ips": []interface{}{"10.0.0.1", "10.0.0.2"},
+		"none":     []interface{}{},
+	}}
+
+	for _, c := range []struct {
+		keys []string
+		want string
+	}{
+		{[]string{"host"}, "db.example.com"},
+		{[]string{"empty", "hostname", "host"}, "db.example.com"},
+		{[]string{"port"}, "5432"},
+		{[]string{"node_ips"}, "10.0.0.1,10.0.0.2"},
+		{[]string{"none", "host"}, "db.example.com"},
+		{[]string{"missing"}, ""},
+	} {
+		if got := svc.String(c.keys...); got != c.want {
+			t.Errorf("String(%q) = %q, want %q", c.keys, got, c.want)
+		}
+	}
+}
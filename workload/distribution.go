@@ -0,0 +1,124 @@
+package workload
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Distribution picks keys out of the [0, n) range, lower keys
+// are the hot ones in skewed distributions. It's safe for
+// concurrent use, n may change between calls.
+type Distribution func(n int64) int64
+
+// Distributions lists available key distributions.
+var Distributions = []SpecInfo{
+	{"uniform", ""},
+	{"zipf", "exponent greater than 1, default 1.1"},
+	{"hotspot", "fraction of hot keys and probability of hitting them, default 0.2:0.8"},
+}
+
+// ParseDistribution parses distribution specs, e.g. "uniform",
+// "zipf:1.5" or "hotspot:0.1:0.9". An empty spec is uniform.
+func ParseDistribution(spec string) (Distribution, error) {
+	args := strings.Split(spec, ":")
+	name, args := args[0], args[1:]
+
+	switch name {
+	case "", "uniform":
+		if len(args) != 0 {
+			return nil, fmt.Errorf("uniform: unexpected arguments")
+		}
+		return uniform, nil
+	case "zipf":
+		s, err := distArgs(args, []float64{1.1})
+		if err != nil {
+			return nil, fmt.Errorf("zipf: %v", err)
+		}
+		if s[0] <= 1 {
+			return nil, fmt.Errorf("zipf: exponent must be greater than 1")
+		}
+		return zipf(s[0]), nil
+	case "hotspot":
+		a, err := distArgs(args, []float64{.2, .8})
+		if err != nil {
+			return nil, fmt.Errorf("hotspot: %v", err)
+		}
+		for _, f := range a {
+			if f <= 0 || f >= 1 {
+				return nil, fmt.Errorf("hotspot: arguments must be in the (0, 1) range")
+			}
+		}
+		return hotspot(a[0], a[1]), nil
+	default:
+		return nil, fmt.Errorf("unknown distribution %q", name)
+	}
+}
+
+// distArgs parses float arguments, missing ones are taken from d.
+func distArgs(args []string, d []float64) ([]float64, error) {
+	if len(args) > len(d) {
+		return nil, fmt.Errorf("too many arguments")
+	}
+
+	res := append([]float64(nil), d...)
+	for i, s := range args {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid argument %q", s)
+		}
+		res[i] = f
+	}
+	return res, nil
+}
+
+func uniform(n int64) int64 {
+	if n <= 1 {
+		return 0
+	}
+	return rand.Int63n(n)
+}
+
+// zipf picks key k with the probability proportional to 1/(k+1)^s.
+func zipf(s float64) Distribution {
+	var (
+		mu sync.Mutex
+		r  = rand.New(rand.NewSource(time.Now().UnixNano()))
+		z  *rand.Zipf
+		zn int64
+	)
+
+	return func(n int64) int64 {
+		if n <= 1 {
+			return 0
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		// it's cheap to build, so it's rebuilt when the range changes
+		if n != zn {
+			z, zn = rand.NewZipf(r, s, 1, uint64(n-1)), n
+		}
+		return int64(z.Uint64())
+	}
+}
+
+// hotspot picks one of the first fraction of keys with probability p
+// and one of the rest otherwise, keys are uniform within the groups.
+func hotspot(fraction, p float64) Distribution {
+	return func(n int64) int64 {
+		hot := int64(float64(n) * fraction)
+		if hot < 1 || hot >= n {
+			return uniform(n)
+		}
+
+		if rand.Float64() < p {
+			return rand.Int63n(hot)
+		}
+		return hot + rand.Int63n(n-hot)
+	}
+}
@@ -0,0 +1,78 @@
+package workload
+
+import "testing"
+
+func TestParseDistribution(t *testing.T) {
+	for _, c := range []struct {
+		spec    string
+		invalid bool
+	}{
+		{spec: ""},
+		{spec: "uniform"},
+		{spec: "zipf"},
+		{spec: "zipf:1.5"},
+		{spec: "hotspot"},
+		{spec: "hotspot:0.1"},
+		{spec: "hotspot:0.1:0.9"},
+		{spec: "uniform:1", invalid: true},
+		{spec: "zipf:1", invalid: true},
+		{spec: "zipf:x", invalid: true},
+		{spec: "zipf:1.5:2", invalid: true},
+		{spec: "hotspot:0", invalid: true},
+		{spec: "hotspot:0.1:1", invalid: true},
+		{spec: "hotspot:0.1:0.9:0.5", invalid: true},
+		{spec: "gauss", invalid: true},
+	} {
+		dist, err := ParseDistribution(c.spec)
+		if c.invalid {
+			if err == nil {
+				t.Errorf("ParseDistribution(%q) succeeded, want an error", c.spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseDistribution(%q) failed: %v", c.spec, err)
+			continue
+		}
+
+		for _, n := range []int64{0, 1, 2, 1000} {
+			for i := 0; i < 1000; i++ {
+				if k := dist(n); k < 0 || (n > 0 && k >= n) || (n == 0 && k != 0) {
+					t.Fatalf("%q picked %d out of %d keys", c.spec, k, n)
+				}
+			}
+		}
+	}
+}
+
+// hotShare returns the share of keys out of 1000 picked by dist that are below 100.
+func hotShare(dist Distribution) float64 {
+	const samples = 100000
+
+	hot := 0
+	for i := 0; i < samples; i++ {
+		if dist(1000) < 100 {
+			hot++
+		}
+	}
+	return float64(hot) / samples
+}
+
+func TestDistributionSkew(t *testing.T) {
+	for _, c := range []struct {
+		spec     string
+		min, max float64
+	}{
+		{"uniform", .08, .12},
+		{"hotspot:0.1:0.9", .88, .92},
+		{"zipf:2", .95, 1},
+	} {
+		dist, err := ParseDistribution(c.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := hotShare(dist); s < c.min || s > c.max {
+			t.Errorf("%q picks the first 10%% of keys %.3f of times, want [%g, %g]", c.spec, s, c.min, c.max)
+		}
+	}
+}
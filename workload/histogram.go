@@ -0,0 +1,88 @@
+package workload
+
+import (
+	"math/bits"
+	"time"
+)
+
+// subBuckets is the number of linear buckets per power of two,
+// it keeps quantile estimations within ~6% of the real value.
+const subBuckets = 16
+
+// Histogram is a log-linear latency histogram with microsecond resolution.
+type Histogram struct {
+	counts []uint64
+	total  uint64
+	max    time.Duration
+}
+
+// Observe records a single latency value.
+func (h *Histogram) Observe(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+
+	b := bucketOf(d)
+	if b >= len(h.counts) {
+		h.counts = append(h.counts, make([]uint64, b-len(h.counts)+1)...)
+	}
+	h.counts[b]++
+	h.total++
+	if d > h.max {
+		h.max = d
+	}
+}
+
+// Count returns the number of observed values.
+func (h *Histogram) Count() uint64 {
+	return h.total
+}
+
+// Max returns the largest observed value.
+func (h *Histogram) Max() time.Duration {
+	return h.max
+}
+
+// Quantile returns the q-quantile upper estimation, q is in the [0, 1] range.
+func (h *Histogram) Quantile(q float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+
+	rank := uint64(q*float64(h.total) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+
+	var n uint64
+	for b, c := range h.counts {
+		if n += c; n >= rank {
+			if v := bucketUpper(b); v < h.max {
+				return v
+			}
+			break
+		}
+	}
+	return h.max
+}
+
+func bucketOf(d time.Duration) int {
+	us := uint64(d / time.Microsecond)
+	if us < 2*subBuckets {
+		return int(us)
+	}
+
+	e := uint(bits.Len64(us) - bits.Len64(2*subBuckets-1))
+	return int(e)*subBuckets + int(us>>e)
+}
+
+// bucketUpper returns the exclusive upper bound of the b bucket.
+func bucketUpper(b int) time.Duration {
+	if b < 2*subBuckets {
+		return time.Duration(b+1) * time.Microsecond
+	}
+
+	e := uint(b/subBuckets - 1)
+	m := uint64(b%subBuckets + subBuckets)
+	return time.Duration((m+1)<<e) * time.Microsecond
+}
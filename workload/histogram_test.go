@@ -0,0 +1,100 @@
+package workload
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBuckets(t *testing.T) {
+	for _, c := range []struct {
+		d     time.Duration
+		b     int
+		upper time.Duration
+	}{
+		{0, 0, time.Microsecond},
+		{999 * time.Nanosecond, 0, time.Microsecond},
+		{time.Microsecond, 1, 2 * time.Microsecond},
+		{31 * time.Microsecond, 31, 32 * time.Microsecond},
+		{32 * time.Microsecond, 32, 34 * time.Microsecond},
+		{33 * time.Microsecond, 32, 34 * time.Microsecond},
+		{34 * time.Microsecond, 33, 36 * time.Microsecond},
+		{63 * time.Microsecond, 47, 64 * time.Microsecond},
+		{64 * time.Microsecond, 48, 68 * time.Microsecond},
+	} {
+		b := bucketOf(c.d)
+		if b != c.b {
+			t.Errorf("bucketOf(%s) = %d, want %d", c.d, b, c.b)
+		}
+		if u := bucketUpper(b); u != c.upper {
+			t.Errorf("bucketUpper(%d) = %s, want %s", b, u, c.upper)
+		}
+	}
+}
+
+func TestBucketBounds(t *testing.T) {
+	prev := time.Duration(0)
+	for d := time.Duration(0); d < 10*time.Second; d = d*9/8 + time.Microsecond {
+		b := bucketOf(d)
+		upper := bucketUpper(b)
+		if d >= upper {
+			t.Fatalf("%s is not below the upper bound %s of its bucket %d", d, upper, b)
+		}
+		if b > 0 && d < bucketUpper(b-1) {
+			t.Fatalf("%s is below the upper bound %s of the previous bucket %d", d, bucketUpper(b-1), b-1)
+		}
+		if upper-upper/16 > d+time.Microsecond {
+			t.Fatalf("upper bound %s of %s is off by more than 1/16", upper, d)
+		}
+		if upper < prev {
+			t.Fatalf("upper bound %s of bucket %d is below the previous one %s", upper, b, prev)
+		}
+		prev = upper
+	}
+}
+
+func TestQuantile(t *testing.T) {
+	var h Histogram
+	if q := h.Quantile(.5); q != 0 {
+		t.Errorf("empty histogram p50 = %s, want 0", q)
+	}
+
+	for i := 1; i <= 1000; i++ {
+		h.Observe(time.Duration(i) * time.Millisecond)
+	}
+	if h.Count() != 1000 {
+		t.Errorf("count = %d, want 1000", h.Count())
+	}
+	if h.Max() != time.Second {
+		t.Errorf("max = %s, want 1s", h.Max())
+	}
+
+	for _, c := range []struct {
+		q    float64
+		want time.Duration
+	}{
+		{0, time.Millisecond},
+		{.5, 500 * time.Millisecond},
+		{.9, 900 * time.Millisecond},
+		{.99, 990 * time.Millisecond},
+		{1, time.Second},
+	} {
+		got := h.Quantile(c.q)
+		if got < c.want || got > c.want+c.want/16 {
+			t.Errorf("quantile %g = %s, want %s within 1/16 above", c.q, got, c.want)
+		}
+	}
+}
+
+func TestQuantileMax(t *testing.T) {
+	var h Histogram
+	h.Observe(100 * time.Millisecond)
+	h.Observe(-time.Second)
+
+	// estimations don't exceed the largest value
+	if q := h.Quantile(1); q != 100*time.Millisecond {
+		t.Errorf("p100 = %s, want 100ms", q)
+	}
+	if q := h.Quantile(0); q != time.Microsecond {
+		t.Errorf("p0 = %s, want 1µs", q)
+	}
+}
@@ -0,0 +1,175 @@
+package workload
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetricsPrefix is prepended to all exported metric names.
+const MetricsPrefix = "cf_monitoring_demo_"
+
+// promBuckets are latency histogram upper bounds in seconds.
+var promBuckets = []float64{
+	.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10,
+}
+
+type seriesKey struct {
+	backend string
+	target  string
+	op      string
+}
+
+// lagOp is the op name schedule lag series are kept under.
+const lagOp = ""
+
+type series struct {
+	ops     uint64
+	errors  uint64
+	buckets []uint64
+	sum     float64
+}
+
+// metrics are cumulative over all runs since the process start,
+// unlike Stats that only cover a single run.
+var metrics = struct {
+	sync.Mutex
+	series map[seriesKey]*series
+}{series: map[seriesKey]*series{}}
+
+func newSeries() *series {
+	return &series{buckets: make([]uint64, len(promBuckets))}
+}
+
+func (s *series) observe(d time.Duration, err error) {
+	sec := d.Seconds()
+	s.ops++
+	s.sum += sec
+	if err != nil {
+		s.errors++
+	}
+	for i, le := range promBuckets {
+		if sec <= le {
+			s.buckets[i]++
+		}
+	}
+}
+
+func (s *series) copy() series {
+	c := *s
+	c.buckets = append([]uint64(nil), s.buckets...)
+	return c
+}
+
+func record(k seriesKey, d time.Duration, err error) {
+	metrics.Lock()
+	defer metrics.Unlock()
+
+	s, ok := metrics.series[k]
+	if !ok {
+		s = newSeries()
+		metrics.series[k] = s
+	}
+	s.observe(d, err)
+}
+
+// WriteMetrics writes operation counters, latency and schedule lag
+// histograms of all targets in the prometheus text exposition format.
+func WriteMetrics(w io.Writer) error {
+	metrics.Lock()
+	var ops, lags []seriesKey
+	snap := make(map[seriesKey]series, len(metrics.series))
+	for k, s := range metrics.series {
+		if k.op == lagOp {
+			lags = append(lags, k)
+		} else {
+			ops = append(ops, k)
+		}
+		snap[k] = s.copy()
+	}
+	metrics.Unlock()
+
+	sortKeys(ops)
+	sortKeys(lags)
+
+	ew := &errWriter{w: w}
+	ew.printf("# HELP %soperations_total Total number of executed operations.\n", MetricsPrefix)
+	ew.printf("# TYPE %soperations_total counter\n", MetricsPrefix)
+	for _, k := range ops {
+		ew.printf("%soperations_total{%s} %d\n", MetricsPrefix, k.labels(), snap[k].ops)
+	}
+
+	ew.printf("# HELP %soperation_errors_total Total number of failed operations.\n", MetricsPrefix)
+	ew.printf("# TYPE %soperation_errors_total counter\n", MetricsPrefix)
+	for _, k := range ops {
+		ew.printf("%soperation_errors_total{%s} %d\n", MetricsPrefix, k.labels(), snap[k].errors)
+	}
+
+	ew.printf("# HELP %soperation_duration_seconds Client-side operation latency.\n", MetricsPrefix)
+	ew.printf("# TYPE %soperation_duration_seconds histogram\n", MetricsPrefix)
+	for _, k := range ops {
+		ew.histogram("operation_duration_seconds", k.labels(), snap[k])
+	}
+
+	ew.printf("# HELP %sschedule_lag_seconds Delay between intended and actual iteration start in the target rate mode.\n", MetricsPrefix)
+	ew.printf("# TYPE %sschedule_lag_seconds histogram\n", MetricsPrefix)
+	for _, k := range lags {
+		ew.histogram("schedule_lag_seconds", k.labels(), snap[k])
+	}
+	return ew.err
+}
+
+func sortKeys(keys []seriesKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.target != b.target {
+			return a.target < b.target
+		}
+		return a.op < b.op
+	})
+}
+
+func (k seriesKey) labels() string {
+	s := fmt.Sprintf("backend=%s,target=%s", QuoteLabel(k.backend), QuoteLabel(k.target))
+	if k.op != lagOp {
+		s += ",operation=" + QuoteLabel(k.op)
+	}
+	return s
+}
+
+var labelReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+// QuoteLabel quotes a label value according to the exposition format,
+// that's not the same as go quoting, e.g. for non-ASCII characters.
+func QuoteLabel(s string) string {
+	return `"` + labelReplacer.Replace(s) + `"`
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// errWriter remembers the first write error and skips all writes after it.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (w *errWriter) histogram(name, labels string, s series) {
+	for i, le := range promBuckets {
+		w.printf("%s%s_bucket{%s,le=%q} %d\n", MetricsPrefix, name, labels, formatFloat(le), s.buckets[i])
+	}
+	w.printf("%s%s_bucket{%s,le=\"+Inf\"} %d\n", MetricsPrefix, name, labels, s.ops)
+	w.printf("%s%s_sum{%s} %s\n", MetricsPrefix, name, labels, formatFloat(s.sum))
+	w.printf("%s%s_count{%s} %d\n", MetricsPrefix, name, labels, s.ops)
+}
+
+func (w *errWriter) printf(format string, a ...interface{}) {
+	if w.err == nil {
+		_, w.err = fmt.Fprintf(w.w, format, a...)
+	}
+}
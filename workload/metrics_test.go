@@ -0,0 +1,62 @@
+package workload
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	defer func(s map[seriesKey]*series) { metrics.series = s }(metrics.series)
+	metrics.series = map[seriesKey]*series{}
+
+	st := NewStats("mysql", `mysql/o"rders\`)
+	st.Observe("select", 500*time.Millisecond, nil)
+	st.Observe("insert", 250*time.Millisecond, nil)
+	st.Observe("insert", 500*time.Millisecond, errors.New("failed"))
+	st.ObserveLag(time.Millisecond)
+
+	var buf bytes.Buffer
+	if err := WriteMetrics(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	const labels = `backend="mysql",target="mysql/o\"rders\\"`
+	for _, want := range []string{
+		"# TYPE cf_monitoring_demo_operations_total counter\n",
+		"cf_monitoring_demo_operations_total{" + labels + `,operation="insert"} 2` + "\n" +
+			"cf_monitoring_demo_operations_total{" + labels + `,operation="select"} 1` + "\n",
+		"cf_monitoring_demo_operation_errors_total{" + labels + `,operation="insert"} 1` + "\n",
+		"cf_monitoring_demo_operation_duration_seconds_bucket{" + labels + `,operation="insert",le="0.1"} 0` + "\n",
+		"cf_monitoring_demo_operation_duration_seconds_bucket{" + labels + `,operation="insert",le="0.25"} 1` + "\n",
+		"cf_monitoring_demo_operation_duration_seconds_bucket{" + labels + `,operation="insert",le="0.5"} 2` + "\n",
+		"cf_monitoring_demo_operation_duration_seconds_bucket{" + labels + `,operation="insert",le="+Inf"} 2` + "\n",
+		"cf_monitoring_demo_operation_duration_seconds_sum{" + labels + `,operation="insert"} 0.75` + "\n",
+		"cf_monitoring_demo_operation_duration_seconds_count{" + labels + `,operation="insert"} 2` + "\n",
+		"cf_monitoring_demo_schedule_lag_seconds_bucket{" + labels + `,le="0.001"} 1` + "\n",
+		"cf_monitoring_demo_schedule_lag_seconds_count{" + labels + "} 1\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("no %q in:\n%s", want, out)
+		}
+	}
+}
+
+func TestQuoteLabel(t *testing.T) {
+	for _, c := range []struct {
+		s    string
+		want string
+	}{
+		{"mysql", `"mysql"`},
+		{`a"b\c`, `"a\"b\\c"`},
+		{"a\nb", `"a\nb"`},
+		{"ünïcode", `"ünïcode"`},
+	} {
+		if got := QuoteLabel(c.s); got != c.want {
+			t.Errorf("QuoteLabel(%q) = %s, want %s", c.s, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package workload
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Option describes a driver specific run option.
+type Option struct {
+	Name  string
+	Usage string
+}
+
+// Options are driver specific run options.
+type Options map[string]string
+
+// ParseOptions parses options in the "k1=v1,k2=v2" form,
+// a key without a value is a boolean option set to true.
+func ParseOptions(s string) (Options, error) {
+	o := Options{}
+	for _, kv := range strings.Split(s, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+
+		k, v := kv, "true"
+		if i := strings.IndexByte(kv, '='); i != -1 {
+			k, v = strings.TrimSpace(kv[:i]), strings.TrimSpace(kv[i+1:])
+		}
+		if k == "" {
+			return nil, fmt.Errorf("malformed option %q", kv)
+		}
+		o[k] = v
+	}
+	return o, nil
+}
+
+// Merge returns a copy of o overridden with values of other.
+func (o Options) Merge(other Options) Options {
+	res := make(Options, len(o)+len(other))
+	for k, v := range o {
+		res[k] = v
+	}
+	for k, v := range other {
+		res[k] = v
+	}
+	return res
+}
+
+// Check returns an error when there's an option that's not in known.
+func (o Options) Check(known []Option) error {
+	var unknown []string
+	for k := range o {
+		ok := false
+		for _, opt := range known {
+			if opt.Name == k {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			unknown = append(unknown, k)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+
+	sort.Strings(unknown)
+	return fmt.Errorf("unknown options: %s", strings.Join(unknown, ", "))
+}
+
+// Bool returns the named boolean option or d when it's not set.
+func (o Options) Bool(name string, d bool) (bool, error) {
+	s, ok := o[name]
+	if !ok {
+		return d, nil
+	}
+
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return d, fmt.Errorf("%s: invalid boolean %q", name, s)
+	}
+	return v, nil
+}
+
+// Int returns the named integer option or d when it's not set.
+func (o Options) Int(name string, d int) (int, error) {
+	s, ok := o[name]
+	if !ok {
+		return d, nil
+	}
+
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return d, fmt.Errorf("%s: invalid integer %q", name, s)
+	}
+	return v, nil
+}
+
+// Float returns the named float option or d when it's not set.
+func (o Options) Float(name string, d float64) (float64, error) {
+	s, ok := o[name]
+	if !ok {
+		return d, nil
+	}
+
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return d, fmt.Errorf("%s: invalid number %q", name, s)
+	}
+	return v, nil
+}
+
+// Duration returns the named duration option or d when it's not set.
+func (o Options) Duration(name string, d time.Duration) (time.Duration, error) {
+	s, ok := o[name]
+	if !ok {
+		return d, nil
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return d, fmt.Errorf("%s: invalid duration %q", name, s)
+	}
+	return v, nil
+}
+
+// Get returns the named option or d when it's not set.
+func (o Options) Get(name, d string) string {
+	if s, ok := o[name]; ok {
+		return s
+	}
+	return d
+}
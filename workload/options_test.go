@@ -0,0 +1,72 @@
+package workload
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseOptions(t *testing.T) {
+	for _, c := range []struct {
+		s       string
+		want    Options
+		invalid bool
+	}{
+		{s: "", want: Options{}},
+		{s: "confirm", want: Options{"confirm": "true"}},
+		{s: " confirm = false , records=10,", want: Options{"confirm": "false", "records": "10"}},
+		{s: "payload=1,payload=2", want: Options{"payload": "2"}},
+		{s: "hold=", want: Options{"hold": ""}},
+		{s: "=1", invalid: true},
+		{s: "records=1,=2", invalid: true},
+	} {
+		o, err := ParseOptions(c.s)
+		switch {
+		case c.invalid && err == nil:
+			t.Errorf("ParseOptions(%q) succeeded, want an error", c.s)
+		case !c.invalid && err != nil:
+			t.Errorf("ParseOptions(%q) failed: %v", c.s, err)
+		case !c.invalid && !reflect.DeepEqual(o, c.want):
+			t.Errorf("ParseOptions(%q) = %v, want %v", c.s, o, c.want)
+		}
+	}
+}
+
+func TestOptionsCheck(t *testing.T) {
+	known := []Option{{Name: "confirm"}, {Name: "records"}}
+
+	if err := (Options{"confirm": "true"}).Check(known); err != nil {
+		t.Errorf("known option: %v", err)
+	}
+	if err := (Options{}).Check(nil); err != nil {
+		t.Errorf("no options: %v", err)
+	}
+
+	err := (Options{"records": "1", "typo": "1", "another": "1"}).Check(known)
+	if want := "unknown options: another, typo"; err == nil || err.Error() != want {
+		t.Errorf("unknown options error is %v, want %q", err, want)
+	}
+}
+
+func TestOptionsValues(t *testing.T) {
+	o := Options{"confirm": "yes", "records": "10", "rate": "2.5", "hold": "1s"}
+
+	if v, err := o.Int("records", 0); v != 10 || err != nil {
+		t.Errorf("Int = %d, %v, want 10", v, err)
+	}
+	if v, err := o.Float("rate", 0); v != 2.5 || err != nil {
+		t.Errorf("Float = %g, %v, want 2.5", v, err)
+	}
+	if v, err := o.Duration("hold", 0); v != time.Second || err != nil {
+		t.Errorf("Duration = %s, %v, want 1s", v, err)
+	}
+	if v, err := o.Bool("missing", true); !v || err != nil {
+		t.Errorf("Bool of a missing option = %t, %v, want the default", v, err)
+	}
+	if _, err := o.Bool("confirm", false); err == nil {
+		t.Error("invalid boolean is accepted")
+	}
+	if _, err := o.Int("rate", 0); err == nil {
+		t.Error("invalid integer is accepted")
+	}
+}
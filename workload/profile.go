@@ -0,0 +1,137 @@
+package workload
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Profile shapes the load over a run, it returns a load factor
+// in the (0, 1] range at the elapsed time t of a run lasting d.
+//
+// The factor scales the target rate in the rate mode and the number
+// of active workers otherwise, active workers are also paused between
+// steps for the load to follow the factor with a few workers.
+type Profile func(t, d time.Duration) float64
+
+// minFactor keeps profiles from stalling the load completely.
+const minFactor = .01
+
+// SpecInfo describes a profile or a distribution for users.
+type SpecInfo struct {
+	Name string
+	Arg  string // argument description, it follows the name after a colon
+}
+
+// Profiles lists available profiles.
+var Profiles = []SpecInfo{
+	{"flat", ""},
+	{"ramp", ""},
+	{"step", "number of plateaus, default 4"},
+	{"sine", "period, default a third of the run"},
+	{"spike", "period, default 1m"},
+}
+
+// ParseProfile parses profile specs, e.g. "ramp", "step:5" or "sine:10m".
+// An empty spec is the flat profile.
+func ParseProfile(spec string) (Profile, error) {
+	name, arg := spec, ""
+	if i := strings.IndexByte(spec, ':'); i != -1 {
+		name, arg = spec[:i], spec[i+1:]
+	}
+
+	switch name {
+	case "", "flat":
+		return flat, nil
+	case "ramp":
+		return ramp, nil
+	case "step":
+		n := 4
+		if arg != "" {
+			var err error
+			if n, err = strconv.Atoi(arg); err != nil || n < 1 {
+				return nil, fmt.Errorf("step: invalid number of plateaus %q", arg)
+			}
+		}
+		return stepped(n), nil
+	case "sine":
+		period, err := parsePeriod(arg, 0)
+		if err != nil {
+			return nil, fmt.Errorf("sine: %v", err)
+		}
+		return sine(period), nil
+	case "spike":
+		period, err := parsePeriod(arg, time.Minute)
+		if err != nil {
+			return nil, fmt.Errorf("spike: %v", err)
+		}
+		return spike(period), nil
+	default:
+		return nil, fmt.Errorf("unknown profile %q", name)
+	}
+}
+
+func parsePeriod(s string, d time.Duration) (time.Duration, error) {
+	if s == "" {
+		return d, nil
+	}
+
+	p, err := time.ParseDuration(s)
+	if err != nil || p <= 0 {
+		return 0, fmt.Errorf("invalid period %q", s)
+	}
+	return p, nil
+}
+
+func flat(t, d time.Duration) float64 {
+	return 1
+}
+
+// ramp grows the load linearly from zero to the full load.
+func ramp(t, d time.Duration) float64 {
+	return clampFactor(float64(t) / float64(d))
+}
+
+// stepped grows the load in n equal plateaus.
+func stepped(n int) Profile {
+	return func(t, d time.Duration) float64 {
+		k := int(float64(t) / float64(d) * float64(n))
+		return clampFactor(float64(k+1) / float64(n))
+	}
+}
+
+// sine oscillates between no and full load starting at the bottom,
+// the zero period is a third of the run.
+func sine(period time.Duration) Profile {
+	return func(t, d time.Duration) float64 {
+		p := period
+		if p == 0 {
+			p = d / 3
+		}
+		return clampFactor(.5 - .5*math.Cos(2*math.Pi*float64(t)/float64(p)))
+	}
+}
+
+// spike runs a fifth of the load with the full load
+// bursts during the first tenth of every period.
+func spike(period time.Duration) Profile {
+	return func(t, d time.Duration) float64 {
+		if t%period < period/10 {
+			return 1
+		}
+		return .2
+	}
+}
+
+func clampFactor(f float64) float64 {
+	switch {
+	case f < minFactor:
+		return minFactor
+	case f > 1:
+		return 1
+	default:
+		return f
+	}
+}
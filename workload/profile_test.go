@@ -0,0 +1,54 @@
+package workload
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParseProfile(t *testing.T) {
+	const d = 100 * time.Second
+
+	for _, c := range []struct {
+		spec    string
+		at      time.Duration
+		factor  float64
+		invalid bool
+	}{
+		{spec: "", at: 0, factor: 1},
+		{spec: "flat", at: 50 * time.Second, factor: 1},
+		{spec: "ramp", at: 0, factor: minFactor},
+		{spec: "ramp", at: 25 * time.Second, factor: .25},
+		{spec: "ramp", at: d, factor: 1},
+		{spec: "step", at: 0, factor: .25},
+		{spec: "step", at: 60 * time.Second, factor: .75},
+		{spec: "step:2", at: 49 * time.Second, factor: .5},
+		{spec: "step:2", at: 50 * time.Second, factor: 1},
+		{spec: "sine", at: 0, factor: minFactor},
+		{spec: "sine", at: d / 6, factor: 1},
+		{spec: "sine:20s", at: 5 * time.Second, factor: .5},
+		{spec: "spike", at: 0, factor: 1},
+		{spec: "spike", at: 10 * time.Second, factor: .2},
+		{spec: "spike:10s", at: 20500 * time.Millisecond, factor: 1},
+		{spec: "step:0", invalid: true},
+		{spec: "step:x", invalid: true},
+		{spec: "sine:-1s", invalid: true},
+		{spec: "spike:1", invalid: true},
+		{spec: "square", invalid: true},
+	} {
+		p, err := ParseProfile(c.spec)
+		if c.invalid {
+			if err == nil {
+				t.Errorf("ParseProfile(%q) succeeded, want an error", c.spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseProfile(%q) failed: %v", c.spec, err)
+			continue
+		}
+		if f := p(c.at, d); math.Abs(f-c.factor) > 1e-9 {
+			t.Errorf("%q factor at %s = %g, want %g", c.spec, c.at, f, c.factor)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package workload
+
+import (
+	"context"
+	"time"
+)
+
+// schedule sends intended start times of iterations to out until ctx is done,
+// they're spaced to make rate iterations per second scaled by the load factor.
+//
+// It's an open-loop schedule: start times are computed from the previous
+// intended time rather than from the moment a worker gets free, so when
+// the backend slows down and workers fall behind, the accumulated delay
+// shows up in latencies instead of silently reducing the load.
+//
+// The wait for the next iteration is split into periods of at most
+// idlePeriod, after each of them the start time is recomputed with the
+// current load factor, so a rising factor isn't ignored for the whole wait.
+func schedule(ctx context.Context, rate float64, factor func() float64, out chan<- time.Time) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	interval := func() time.Duration {
+		return time.Duration(float64(time.Second) / (rate * factor()))
+	}
+
+	for prev, next := time.Now(), time.Now(); ; prev, next = next, next.Add(interval()) {
+		for {
+			d := time.Until(next)
+			if d <= 0 {
+				break
+			}
+			if d > idlePeriod {
+				d = idlePeriod
+			}
+
+			timer.Reset(d)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				return
+			}
+
+			// the start time is brought forward when the factor has
+			// risen, but not before the moment it's noticed, and
+			// timer delays don't push it back
+			earliest := time.Now()
+			if next.Before(earliest) {
+				earliest = next
+			}
+			if next = prev.Add(interval()); next.Before(earliest) {
+				next = earliest
+			}
+		}
+
+		select {
+		case out <- next:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
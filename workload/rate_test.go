@@ -0,0 +1,106 @@
+package workload
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingWorkload counts steps and does nothing else.
+type countingWorkload struct {
+	steps int64
+}
+
+func (w *countingWorkload) Name() string                       { return "counting" }
+func (w *countingWorkload) Configure(cfg Config) error         { return nil }
+func (w *countingWorkload) Setup(ctx context.Context) error    { return nil }
+func (w *countingWorkload) Teardown(ctx context.Context) error { return nil }
+
+func (w *countingWorkload) Step(ctx context.Context, i int) error {
+	atomic.AddInt64(&w.steps, 1)
+	return nil
+}
+
+func TestSchedule(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ticks := make(chan time.Time)
+	go schedule(ctx, 100, func() float64 { return 1 }, ticks)
+
+	// a slow consumer doesn't delay intended start times
+	prev := <-ticks
+	for i := 0; i < 5; i++ {
+		time.Sleep(30 * time.Millisecond)
+		next := <-ticks
+		if d := next.Sub(prev); d != 10*time.Millisecond {
+			t.Errorf("tick %d is %s after the previous one, want 10ms", i+1, d)
+		}
+		prev = next
+	}
+}
+
+func TestScheduleFactor(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the factor rises in the middle of a 10s wait
+	start := time.Now()
+	factor := func() float64 {
+		if time.Since(start) < 150*time.Millisecond {
+			return .01
+		}
+		return 1
+	}
+
+	ticks := make(chan time.Time)
+	go schedule(ctx, 10, factor, ticks)
+
+	<-ticks
+	select {
+	case <-ticks:
+		if d := time.Since(start); d < 150*time.Millisecond {
+			t.Errorf("second tick after %s, before the factor has risen", d)
+		}
+	case <-time.After(time.Second):
+		t.Error("no second tick after the factor has risen")
+	}
+}
+
+func TestProfiledRate(t *testing.T) {
+	const (
+		rate     = 20
+		duration = 3 * time.Second
+	)
+
+	for _, c := range []struct {
+		profile string
+		want    float64 // average load factor
+	}{
+		{"flat", 1},
+		{"ramp", .5},
+		{"sine:1s", .5},
+	} {
+		c := c
+		t.Run(c.profile, func(t *testing.T) {
+			t.Parallel()
+
+			p, err := ParseProfile(c.profile)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			w := &countingWorkload{}
+			err, _ = Run(context.Background(), w, Config{Workers: 2, Rate: rate, Duration: duration, Profile: p}, NewStats("counting", "counting"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := rate * duration.Seconds() * c.want
+			if n := float64(w.steps); n < want*.75 || n > want*1.25 {
+				t.Errorf("%d steps, want about %.0f", w.steps, want)
+			}
+		})
+	}
+}
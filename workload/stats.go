@@ -0,0 +1,220 @@
+package workload
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Stats collects per-operation counters and latencies of a run.
+type Stats struct {
+	backend string
+	target  string
+
+	mu      sync.Mutex
+	started time.Time
+	stopped time.Time
+	ops     map[string]*opStats
+	lag     Histogram
+	gauges  map[string]int64
+}
+
+type opStats struct {
+	errors uint64
+	hist   Histogram
+}
+
+// OpStats is a point in time summary of a single operation type.
+type OpStats struct {
+	Op     string
+	Count  uint64
+	Errors uint64
+	Rate   float64 // operations per second
+	P50    time.Duration
+	P90    time.Duration
+	P99    time.Duration
+	Max    time.Duration
+}
+
+// NewStats returns an empty stats collector of a run against the target
+// backend instance, its run clock starts immediately until Start restarts it.
+func NewStats(backend, target string) *Stats {
+	return &Stats{
+		backend: backend,
+		target:  target,
+		started: time.Now(),
+		ops:     map[string]*opStats{},
+		gauges:  map[string]int64{},
+	}
+}
+
+// Observe records a single op execution that took d and failed when err isn't nil.
+// It's also accounted in the process-wide metrics.
+func (s *Stats) Observe(op string, d time.Duration, err error) {
+	record(seriesKey{s.backend, s.target, op}, d, err)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.ops[op]
+	if !ok {
+		o = &opStats{}
+		s.ops[op] = o
+	}
+	o.hist.Observe(d)
+	if err != nil {
+		o.errors++
+	}
+}
+
+// ObserveLag records a delay between the intended and
+// actual start of an iteration in the target rate mode.
+func (s *Stats) ObserveLag(d time.Duration) {
+	record(seriesKey{s.backend, s.target, lagOp}, d, nil)
+
+	s.mu.Lock()
+	s.lag.Observe(d)
+	s.mu.Unlock()
+}
+
+// Lag returns the schedule lag summary, Count is zero unless
+// the run is in the target rate mode.
+func (s *Stats) Lag() OpStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return summary("lag", &s.lag, 0, s.elapsed().Seconds())
+}
+
+// SetGauge sets a point in time driver specific value,
+// e.g. the number of messages waiting in a queue.
+func (s *Stats) SetGauge(name string, v int64) {
+	s.mu.Lock()
+	s.gauges[name] = v
+	s.mu.Unlock()
+}
+
+// Gauge is a named driver specific value.
+type Gauge struct {
+	Name  string
+	Value int64
+}
+
+// Gauges returns all set gauges sorted by name.
+func (s *Stats) Gauges() []Gauge {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := make([]Gauge, 0, len(s.gauges))
+	for name, v := range s.gauges {
+		res = append(res, Gauge{name, v})
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// Start restarts the run clock, so the time it takes to set the workload
+// up isn't accounted in rates.
+func (s *Stats) Start() {
+	s.mu.Lock()
+	s.started = time.Now()
+	s.mu.Unlock()
+}
+
+// Stop freezes the run clock so rates don't decay after the run is over.
+func (s *Stats) Stop() {
+	s.mu.Lock()
+	if s.stopped.IsZero() {
+		s.stopped = time.Now()
+	}
+	s.mu.Unlock()
+}
+
+// Elapsed returns the run duration so far.
+func (s *Stats) Elapsed() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.elapsed()
+}
+
+func (s *Stats) elapsed() time.Duration {
+	if s.stopped.IsZero() {
+		return time.Since(s.started)
+	}
+	return s.stopped.Sub(s.started)
+}
+
+// Snapshot returns summaries of all observed operations sorted by name.
+func (s *Stats) Snapshot() []OpStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sec := s.elapsed().Seconds()
+	res := make([]OpStats, 0, len(s.ops))
+	for op, o := range s.ops {
+		res = append(res, summary(op, &o.hist, o.errors, sec))
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Op < res[j].Op
+	})
+	return res
+}
+
+func summary(op string, h *Histogram, errors uint64, sec float64) OpStats {
+	st := OpStats{
+		Op:     op,
+		Count:  h.Count(),
+		Errors: errors,
+		P50:    h.Quantile(.5),
+		P90:    h.Quantile(.9),
+		P99:    h.Quantile(.99),
+		Max:    h.Max(),
+	}
+	if sec > 0 {
+		st.Rate = float64(st.Count) / sec
+	}
+	return st
+}
+
+type statsKey struct{}
+
+// WithStats returns a copy of ctx that Measure records operations to.
+func WithStats(ctx context.Context, s *Stats) context.Context {
+	return context.WithValue(ctx, statsKey{}, s)
+}
+
+// Measure runs fn and records its latency and result as op
+// to the stats attached to ctx, if any.
+func Measure(ctx context.Context, op string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	Record(ctx, op, time.Since(start), err)
+	return err
+}
+
+// Record records an op that took d to the stats attached to ctx, if any.
+// It's meant for operations that can't be wrapped with Measure,
+// e.g. the ones completing asynchronously.
+//
+// Nothing is recorded once ctx is done, operations are interrupted
+// by the end of the run then and their errors aren't real.
+func Record(ctx context.Context, op string, d time.Duration, err error) {
+	if ctx.Err() != nil {
+		return
+	}
+	if s, ok := ctx.Value(statsKey{}).(*Stats); ok {
+		s.Observe(op, d, err)
+	}
+}
+
+// SetGauge sets the named gauge of the stats attached to ctx, if any.
+func SetGauge(ctx context.Context, name string, v int64) {
+	if s, ok := ctx.Value(statsKey{}).(*Stats); ok {
+		s.SetGauge(name, v)
+	}
+}
@@ -0,0 +1,363 @@
+// Package workload defines the load generator interface implemented by
+// backend drivers and the registry the HTTP frontend is generated from.
+package workload
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cf-monitoring-demo-app/vcap"
+)
+
+// Workload generates load against a single backend.
+//
+// A fresh Workload is created for every run, it's configured with the
+// run configuration, then Setup is called once, Step is called in a loop
+// by every worker until the run is over and Teardown releases everything
+// Setup acquired. Teardown is called even when Setup fails, so it has to
+// cope with a partially set up workload.
+type Workload interface {
+	// Name returns the driver name the workload belongs to.
+	Name() string
+
+	// Configure parses the backend address and run options.
+	Configure(cfg Config) error
+
+	// Setup connects to the backend and prepares it for the run,
+	// connection pools should be sized for cfg.Workers.
+	Setup(ctx context.Context) error
+
+	// Step performs the i-th iteration of the workload,
+	// it's called concurrently when there's more than one worker.
+	Step(ctx context.Context, i int) error
+
+	// Teardown removes everything created by the run and disconnects,
+	// connections have to be released even when the cleanup fails.
+	Teardown(ctx context.Context) error
+}
+
+// TeardownExtender is implemented by workloads whose teardown takes
+// longer depending on the run, e.g. the ones draining queues at a rate.
+type TeardownExtender interface {
+	// TeardownExtra returns how much the teardown timeout is extended,
+	// it's called once the run is over, right before the teardown.
+	TeardownExtra() time.Duration
+}
+
+// Config is a run configuration.
+type Config struct {
+	// Addr is the backend address.
+	Addr string
+
+	// Namespace is unique to the run across all application instances,
+	// names of tables, keys, collections and queues the run creates are
+	// prefixed with it, so concurrent runs don't touch each other's data.
+	// It's a lowercase identifier valid in all supported backends.
+	Namespace string
+
+	// Workers is the number of goroutines calling Step in parallel.
+	Workers int
+
+	// Rate is the target number of steps per second of all workers,
+	// workers loop as fast as they can when it's zero.
+	Rate float64
+
+	// Duration is the planned run duration the Profile is stretched to.
+	Duration time.Duration
+
+	// Profile shapes the rate or the load of workers over the
+	// run when there's no target rate, nil is the flat profile.
+	Profile Profile
+
+	// Options are driver specific options, they're checked
+	// against the driver Options before the run.
+	Options Options
+
+	// TeardownTimeout limits the teardown duration,
+	// DefaultTeardownTimeout is used when it's zero,
+	// it's extended for workloads implementing TeardownExtender.
+	TeardownTimeout time.Duration
+}
+
+// DefaultTeardownTimeout is the teardown timeout used when it's not configured.
+const DefaultTeardownTimeout = 30 * time.Second
+
+// Driver describes a registered workload.
+type Driver struct {
+	// Name is used as the route path and as the busy state key.
+	Name string
+
+	// Title is the index page button label.
+	Title string
+
+	// Style is the bootstrap button style, e.g. "primary" or "danger".
+	Style string
+
+	// Env is the environment variable the backend address is read from.
+	Env string
+
+	// Services are labels and tags of cloud foundry services the driver
+	// is configured from when the environment variable is not set.
+	Services []string
+
+	// ServiceAddr converts bound service credentials to the backend address.
+	ServiceAddr func(svc *vcap.Service) (string, error)
+
+	// Options are run options the driver understands.
+	Options []Option
+
+	// New creates a new unconfigured workload.
+	New func() Workload
+}
+
+var (
+	mu      sync.Mutex
+	drivers []*Driver
+)
+
+// Register makes a driver available, it panics when the name is taken.
+// Drivers are listed in the order they are registered.
+func Register(d *Driver) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, r := range drivers {
+		if r.Name == d.Name {
+			panic(fmt.Sprintf("workload: driver %q is already registered", d.Name))
+		}
+	}
+	drivers = append(drivers, d)
+}
+
+// Drivers returns all registered drivers.
+func Drivers() []*Driver {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return append([]*Driver(nil), drivers...)
+}
+
+// Run configures and sets w up, then steps it with cfg.Workers workers
+// for cfg.Duration, until ctx is done or a step fails, then tears it down.
+// The duration is counted from the end of the setup, so seeding
+// doesn't eat into it.
+// Operations measured by the workload are recorded to st.
+//
+// The workload error and the teardown error are returned separately,
+// the teardown is done in spite of ctx being done or the run failing.
+func Run(ctx context.Context, w Workload, cfg Config, st *Stats) (err, teardownErr error) {
+	defer st.Stop()
+
+	if cfg.Workers < 1 {
+		cfg.Workers = 1
+	}
+	if cfg.Profile == nil {
+		cfg.Profile = flat
+	}
+	if cfg.TeardownTimeout <= 0 {
+		cfg.TeardownTimeout = DefaultTeardownTimeout
+	}
+	if err := w.Configure(cfg); err != nil {
+		return err, nil
+	}
+
+	defer func() {
+		// the teardown isn't accounted in rates either
+		st.Stop()
+
+		timeout := cfg.TeardownTimeout
+		if e, ok := w.(TeardownExtender); ok {
+			timeout += e.TeardownExtra()
+		}
+		teardownErr = teardown(w, timeout, st)
+	}()
+
+	// the setup context is done once the run is over, so that anything
+	// the workload has started in background stops before the teardown
+	ctx, cancel := context.WithCancel(WithStats(ctx, st))
+	defer cancel()
+	if err := w.Setup(ctx); err != nil {
+		return err, nil
+	}
+
+	st.Start()
+	return step(ctx, w, cfg, st), nil
+}
+
+// teardown tears w down with the given timeout, when it's exceeded
+// the teardown is abandoned, since not all backend clients can be
+// interrupted, and the timeout error is returned.
+func teardown(w Workload, timeout time.Duration, st *Stats) error {
+	ctx, cancel := context.WithTimeout(WithStats(context.Background(), st), timeout)
+	defer cancel()
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- w.Teardown(ctx)
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("teardown timed out after %s", timeout)
+	}
+}
+
+// step runs cfg.Workers workers sharing the iteration counter for
+// cfg.Duration and returns the first step error, if any.
+//
+// In the target rate mode workers start iterations at scheduled times,
+// the delay between the intended and actual start is recorded as the
+// schedule lag and the "step" op latency is counted from the intended
+// start, so it includes the time an iteration has waited for a worker.
+func step(ctx context.Context, w Workload, cfg Config, st *Stats) error {
+	var cancel context.CancelFunc
+	if cfg.Duration > 0 {
+		ctx, cancel = context.WithTimeout(ctx, cfg.Duration)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+
+	start := time.Now()
+	factor := func() float64 {
+		return cfg.Profile(time.Since(start), cfg.Duration)
+	}
+
+	var ticks chan time.Time
+	if cfg.Rate > 0 {
+		ticks = make(chan time.Time)
+		go schedule(ctx, cfg.Rate, factor, ticks)
+	}
+
+	var (
+		wg   sync.WaitGroup
+		once sync.Once
+		err  error
+		next int64 = -1
+	)
+
+	wg.Add(cfg.Workers)
+	for k := 0; k < cfg.Workers; k++ {
+		k := k
+		go func() {
+			defer wg.Done()
+
+			var th throttler
+			for ctx.Err() == nil {
+				if ticks == nil && k >= activeWorkers(cfg.Workers, factor()) {
+					idle(ctx)
+					continue
+				}
+
+				var intended time.Time
+				if ticks != nil {
+					select {
+					case intended = <-ticks:
+					case <-ctx.Done():
+						return
+					}
+					st.ObserveLag(time.Since(intended))
+				}
+
+				began := time.Now()
+				serr := w.Step(ctx, int(atomic.AddInt64(&next, 1)))
+				if ticks != nil && ctx.Err() == nil {
+					st.Observe("step", time.Since(intended), serr)
+				}
+				// steps interrupted by the end of the run aren't failures
+				if serr != nil && ctx.Err() == nil {
+					once.Do(func() {
+						err = serr
+						cancel()
+					})
+					return
+				}
+				if ticks == nil {
+					th.wait(ctx, time.Since(began), cfg.Workers, factor)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return err
+}
+
+// activeWorkers returns the number of workers out of n that should be
+// stepping at the load factor f, at least one of them is always active.
+func activeWorkers(n int, f float64) int {
+	if a := int(math.Ceil(float64(n) * f)); a > 1 {
+		return a
+	}
+	return 1
+}
+
+// workerShare returns the share of time each of the active workers out
+// of n should be stepping at the load factor f, so that the load follows
+// the factor even when there are only a few workers.
+func workerShare(n int, f float64) float64 {
+	if g := float64(n) * f / float64(activeWorkers(n, f)); g < 1 {
+		return g
+	}
+	return 1
+}
+
+// throttler pauses an active worker between steps, so that it's only
+// stepping its share of time at the current load factor.
+type throttler struct {
+	// busy is the stepping time not compensated with pauses yet,
+	// it's negative when pauses have overslept
+	busy time.Duration
+}
+
+// minPause keeps pauses longer than the timer resolution,
+// shorter ones are accumulated.
+const minPause = time.Millisecond
+
+// wait pauses an active worker of n after a step that took d, long pauses
+// are split, so they're reconsidered when the load factor changes.
+func (t *throttler) wait(ctx context.Context, d time.Duration, n int, factor func() float64) {
+	t.busy += d
+	for ctx.Err() == nil {
+		g := workerShare(n, factor())
+		if g >= 1 {
+			t.busy = 0
+			return
+		}
+
+		left := time.Duration(float64(t.busy) * (1 - g) / g)
+		if left < minPause {
+			return
+		}
+		if left > idlePeriod {
+			left = idlePeriod
+		}
+
+		start := time.Now()
+		pause(ctx, left)
+		t.busy -= time.Duration(float64(time.Since(start)) * g / (1 - g))
+	}
+}
+
+// idlePeriod is how often paused workers check the load factor.
+const idlePeriod = 100 * time.Millisecond
+
+func idle(ctx context.Context) {
+	pause(ctx, idlePeriod)
+}
+
+// pause waits for d or until ctx is done.
+func pause(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
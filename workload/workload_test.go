@@ -0,0 +1,65 @@
+package workload
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestActiveWorkers(t *testing.T) {
+	for _, c := range []struct {
+		n    int
+		f    float64
+		want int
+	}{
+		{4, 1, 4},
+		{4, .5, 2},
+		{4, .3, 2},
+		{4, 0, 1},
+		{1, .1, 1},
+		{10, .01, 1},
+	} {
+		if got := activeWorkers(c.n, c.f); got != c.want {
+			t.Errorf("activeWorkers(%d, %g) = %d, want %d", c.n, c.f, got, c.want)
+		}
+	}
+}
+
+func TestWorkerShare(t *testing.T) {
+	for _, c := range []struct {
+		n    int
+		f    float64
+		want float64
+	}{
+		{4, 1, 1},
+		{4, .5, 1},
+		{4, .3, .6},
+		{1, .25, .25},
+		{4, 0, 0},
+	} {
+		if got := workerShare(c.n, c.f); math.Abs(got-c.want) > 1e-9 {
+			t.Errorf("workerShare(%d, %g) = %g, want %g", c.n, c.f, got, c.want)
+		}
+	}
+}
+
+func TestThrottler(t *testing.T) {
+	const step = 10 * time.Millisecond
+
+	var (
+		th    throttler
+		steps int
+	)
+	start := time.Now()
+	for time.Since(start) < 500*time.Millisecond {
+		time.Sleep(step)
+		steps++
+		th.wait(context.Background(), step, 1, func() float64 { return .25 })
+	}
+
+	// the only worker is stepping a quarter of time
+	if share := float64(steps) * step.Seconds() / time.Since(start).Seconds(); share < .2 || share > .3 {
+		t.Errorf("worker is stepping %.2f of time, want .25", share)
+	}
+}